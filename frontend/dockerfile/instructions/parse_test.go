@@ -126,6 +126,27 @@ func TestHealthCheckCmd(t *testing.T) {
 	assert.Check(t, is.DeepEqual(expected, hc.Health.Test))
 }
 
+func TestArgDefaults(t *testing.T) {
+	for dockerfile, expected := range map[string]*string{
+		"ARG VERSION":     nil,
+		"ARG VERSION=":    strPtr(""),
+		"ARG VERSION=1.0": strPtr("1.0"),
+	} {
+		ast, err := parser.Parse(strings.NewReader(dockerfile))
+		assert.NilError(t, err)
+		cmd, err := ParseInstruction(ast.AST.Children[0])
+		assert.NilError(t, err)
+		arg, ok := cmd.(*ArgCommand)
+		assert.Assert(t, ok)
+		assert.Check(t, is.Equal("VERSION", arg.Key), dockerfile)
+		assert.Check(t, is.DeepEqual(expected, arg.Value), dockerfile)
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
 func TestParseOptInterval(t *testing.T) {
 	flInterval := &Flag{
 		name:     "interval",
@@ -0,0 +1,371 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/moby/buildkit/frontend/dockerfile/command"
+)
+
+// checkInstruction validates a parsed instruction and returns every problem
+// found, without line. The problems are reported as warnings, or as errors
+// in strict mode.
+func checkInstruction(node *Node, d *Directive) []Warning {
+	if d.lookupCommand(node.Value) == nil {
+		return withRule(RuleUnknownInstruction, fmt.Sprintf("unknown instruction: %s", strings.ToUpper(node.Value)))
+	}
+	var problems []Warning
+	if ch, ok := unrecognizedSpace(node.Original); ok {
+		problems = withRule(RuleUnrecognizedSpace, fmt.Sprintf("instruction contains the space character %U, which doesn't separate arguments", ch))
+	}
+	return append(problems, checkArguments(node, d)...)
+}
+
+// checkArguments validates the arguments of the instructions that have
+// specific requirements.
+func checkArguments(node *Node, d *Directive) []Warning {
+	switch node.Value {
+	case command.Env, command.Label:
+		return withRule(RuleLegacyKeyValueFormat, checkKeyValues(node, d)...)
+	case command.Expose:
+		return withRule(RuleInvalidExposePort, checkExpose(node)...)
+	case command.Add:
+		return append(withRule(RuleInvalidCopyFlag, checkCopyFlags(node)...), withRule(RuleInvalidChecksum, checkAddChecksum(node)...)...)
+	case command.Copy:
+		return withRule(RuleInvalidCopyFlag, checkCopyFlags(node)...)
+	case command.From:
+		return withRule(RuleInvalidPlatform, checkFromPlatform(node)...)
+	case command.Onbuild:
+		return checkOnbuild(node, d)
+	case command.Healthcheck:
+		return withRule(RuleInvalidHealthcheck, checkHealthcheck(node)...)
+	case command.Run:
+		return append(withRule(RuleInvalidRunMount, checkRunMounts(node)...), withRule(RuleInvalidRunFlag, checkRunModes(node)...)...)
+	case command.Shell:
+		return withRule(RuleShellRequiresJSONForm, checkShell(node)...)
+	case command.StopSignal:
+		return withRule(RuleInvalidStopSignal, checkStopSignal(node)...)
+	case command.User:
+		return withRule(RuleInvalidUser, checkUser(node)...)
+	}
+	return nil
+}
+
+// withRule returns the problems found by the checks of rule as warnings
+func withRule(rule string, problems ...string) []Warning {
+	var warnings []Warning
+	for _, problem := range problems {
+		warnings = append(warnings, Warning{RuleID: rule, Message: problem, Severity: SeverityWarning})
+	}
+	return warnings
+}
+
+// unrecognizedSpace returns the first Unicode space character in s that the
+// parser doesn't treat as whitespace, e.g. a non-breaking space.
+func unrecognizedSpace(s string) (rune, bool) {
+	for _, ch := range s {
+		if unicode.IsSpace(ch) && !isWhitespace(ch) {
+			return ch, true
+		}
+	}
+	return 0, false
+}
+
+// checkDeprecated returns a deprecation notice for instructions that are
+// still accepted but should no longer be used. Unlike the problems returned
+// by checkInstruction these are never turned into errors.
+func checkDeprecated(node *Node) string {
+	if node.Value == command.Maintainer {
+		return "MAINTAINER instruction is deprecated, use LABEL maintainer=\"name\" instead"
+	}
+	return ""
+}
+
+// checkCasing returns a warning if the keyword of an instruction, as
+// written in the original line, mixes upper and lower case, e.g. `Copy`.
+func checkCasing(node *Node) string {
+	fields := strings.Fields(node.Original)
+	if len(fields) == 0 {
+		return ""
+	}
+	keyword := fields[0]
+	if keyword == strings.ToUpper(keyword) || keyword == strings.ToLower(keyword) {
+		return ""
+	}
+	return fmt.Sprintf("instruction %s should be either all uppercase or all lowercase", keyword)
+}
+
+// checkKeyValues warns about ENV and LABEL instructions in the legacy
+// `ENV key value` form whose value looks like key=value pairs, e.g.
+// `ENV a b=c`, which sets a to "b=c". Valueless and mixed forms starting with
+// a pair, e.g. `ENV a=b c`, are rejected while parsing.
+func checkKeyValues(node *Node, d *Directive) []string {
+	words, legacy := legacyKeyValue(node, d)
+	if !legacy {
+		return nil
+	}
+	for _, word := range words[1:] {
+		if strings.Contains(word, "=") {
+			return []string{fmt.Sprintf("%[1]s mixes the legacy \"%[1]s key value\" form with the key=value form, %[2]s is set to %[3]q", strings.ToUpper(node.Value), node.Next.Value, node.Next.Next.Value)}
+		}
+	}
+	return nil
+}
+
+// legacyKeyValue reports whether an ENV or LABEL instruction is in the legacy
+// `ENV key value` form, and returns the words of its arguments
+func legacyKeyValue(node *Node, d *Directive) ([]string, bool) {
+	_, _, args, err := splitCommand(node.Original)
+	if err != nil {
+		return nil, false
+	}
+	words := parseWords(args, d)
+	return words, len(words) >= 2 && !strings.Contains(words[0], "=")
+}
+
+// checkExpose validates the ports of an EXPOSE instruction, which are of the
+// form port[-port][/protocol]. Ports referencing variables can't be checked.
+func checkExpose(node *Node) []string {
+	var problems []string
+	for n := node.Next; n != nil; n = n.Next {
+		if strings.Contains(n.Value, "$") {
+			continue
+		}
+		if err := validatePortSpec(n.Value); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid EXPOSE port %q: %s", n.Value, err))
+		}
+	}
+	return problems
+}
+
+// checkCopyFlags validates the --chown, --chmod and --link flags of an ADD or COPY
+// instruction.
+func checkCopyFlags(node *Node) []string {
+	var problems []string
+	for _, value := range flagValues(node.Flags, "chown") {
+		if _, err := ParseChown(value); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid --chown: %s", err))
+		}
+	}
+	for _, value := range flagValues(node.Flags, "chmod") {
+		if _, err := ParseChmod(value); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid --chmod: %s", err))
+		}
+	}
+	for _, value := range flagValues(node.Flags, "link") {
+		if _, err := parseBoolFlag("link", value); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid --link: %s", err))
+		}
+	}
+	return problems
+}
+
+// checkAddChecksum validates the --checksum flag of an ADD instruction,
+// which can only be verified for remote sources.
+func checkAddChecksum(node *Node) []string {
+	values := flagValues(node.Flags, "checksum")
+	if len(values) == 0 {
+		return nil
+	}
+	var problems []string
+	for _, value := range values {
+		if _, err := ParseChecksum(value); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid --checksum: %s", err))
+		}
+	}
+	// the last argument is the destination
+	for n := node.Next; n != nil && n.Next != nil; n = n.Next {
+		if !isRemoteSource(n.Value) {
+			problems = append(problems, fmt.Sprintf("--checksum is only supported for remote sources, not %q", n.Value))
+		}
+	}
+	return problems
+}
+
+// isRemoteSource reports whether an ADD source is fetched from a URL, or
+// may be because it references a build argument
+func isRemoteSource(src string) bool {
+	return strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") || strings.Contains(src, "$")
+}
+
+// checkFromPlatform validates the --platform flags of a FROM instruction.
+func checkFromPlatform(node *Node) []string {
+	var problems []string
+	for _, value := range flagValues(node.Flags, "platform") {
+		if _, err := ParsePlatform(value); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid --platform: %s", err))
+		}
+	}
+	return problems
+}
+
+// checkHealthcheck validates the flags of a HEALTHCHECK instruction and makes
+// sure HEALTHCHECK NONE has no arguments.
+func checkHealthcheck(node *Node) []string {
+	var problems []string
+	for _, flag := range node.Flags {
+		parts := strings.SplitN(strings.TrimPrefix(flag, "--"), "=", 2)
+		value := ""
+		if len(parts) == 2 {
+			value = parts[1]
+		}
+		switch name := parts[0]; name {
+		case "interval", "timeout", "start-period":
+			if _, err := parseDurationFlag(name, value); err != nil {
+				problems = append(problems, "HEALTHCHECK "+err.Error())
+			}
+		case "retries":
+			if n, err := strconv.Atoi(value); err != nil || n < 1 {
+				problems = append(problems, fmt.Sprintf("invalid HEALTHCHECK --retries %q: must be a positive integer", value))
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("unknown HEALTHCHECK flag %q", flag))
+		}
+	}
+	if node.Next != nil && strings.EqualFold(node.Next.Value, "none") && node.Next.Next != nil {
+		problems = append(problems, "HEALTHCHECK NONE takes no arguments")
+	}
+	return problems
+}
+
+// checkRunMounts validates every --mount flag of a RUN instruction.
+func checkRunMounts(node *Node) []string {
+	var problems []string
+	for _, value := range flagValues(node.Flags, "mount") {
+		if _, err := ParseMount(value); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid --mount %q: %s", value, err))
+		}
+	}
+	return problems
+}
+
+// checkOnbuild rejects the instructions that can't be ONBUILD triggers and
+// validates the arguments of the others.
+func checkOnbuild(node *Node, d *Directive) []Warning {
+	if node.Next == nil || len(node.Next.Children) == 0 {
+		return nil
+	}
+	trigger := node.Next.Children[0]
+	switch trigger.Value {
+	case command.Onbuild, command.From, command.Maintainer:
+		return withRule(RuleInvalidOnbuildTrigger, fmt.Sprintf("%s isn't allowed as an ONBUILD trigger", strings.ToUpper(trigger.Value)))
+	}
+	if d.lookupCommand(trigger.Value) == nil {
+		return withRule(RuleUnknownInstruction, fmt.Sprintf("unknown instruction in ONBUILD: %s", strings.ToUpper(trigger.Value)))
+	}
+	return checkArguments(trigger, d)
+}
+
+// checkRunModes validates the --network and --security flags of a RUN
+// instruction.
+func checkRunModes(node *Node) []string {
+	var problems []string
+	if _, err := RunNetwork(node); err != nil {
+		problems = append(problems, fmt.Sprintf("RUN %s", err))
+	}
+	if _, err := RunSecurity(node); err != nil {
+		problems = append(problems, fmt.Sprintf("RUN %s", err))
+	}
+	return problems
+}
+
+// checkShell makes sure SHELL is given in JSON form, which is the only form
+// it supports.
+func checkShell(node *Node) []string {
+	if !node.IsJSON() || node.Next == nil {
+		return []string{`SHELL requires the arguments to be in JSON form, e.g. SHELL ["powershell", "-command"]`}
+	}
+	return nil
+}
+
+// checkUser makes sure USER is given a user and an optional group
+func checkUser(node *Node) []string {
+	if node.Next == nil {
+		return nil
+	}
+	if _, err := ParseUser(node.Next.Value); err != nil {
+		return []string{"invalid USER: " + err.Error()}
+	}
+	return nil
+}
+
+// signalNames are the names of the Linux signals, without the SIG prefix
+var signalNames = map[string]struct{}{
+	"ABRT": {}, "ALRM": {}, "BUS": {}, "CHLD": {}, "CLD": {}, "CONT": {},
+	"FPE": {}, "HUP": {}, "ILL": {}, "INT": {}, "IO": {}, "IOT": {},
+	"KILL": {}, "PIPE": {}, "POLL": {}, "PROF": {}, "PWR": {}, "QUIT": {},
+	"SEGV": {}, "STKFLT": {}, "STOP": {}, "SYS": {}, "TERM": {}, "TRAP": {},
+	"TSTP": {}, "TTIN": {}, "TTOU": {}, "URG": {}, "USR1": {}, "USR2": {},
+	"VTALRM": {}, "WINCH": {}, "XCPU": {}, "XFSZ": {},
+}
+
+// realtimeSignal matches the names of realtime signals relative to the
+// first and the last one, without the SIG prefix, e.g. RTMIN+3
+var realtimeSignal = regexp.MustCompile(`^RTMIN(\+[0-9]+)?$|^RTMAX(-[0-9]+)?$`)
+
+// checkStopSignal makes sure STOPSIGNAL is given a signal number or a
+// signal name with the SIG prefix, e.g. SIGTERM. Signals referencing
+// variables can't be checked.
+func checkStopSignal(node *Node) []string {
+	if node.Next == nil || strings.Contains(node.Next.Value, "$") {
+		return nil
+	}
+	signal := node.Next.Value
+	if n, err := strconv.Atoi(signal); err == nil {
+		if n < 1 {
+			return []string{fmt.Sprintf("invalid STOPSIGNAL %q: signal numbers must be positive", signal)}
+		}
+		return nil
+	}
+	name := strings.ToUpper(signal)
+	if !strings.HasPrefix(name, "SIG") {
+		if _, ok := signalNames[name]; ok || realtimeSignal.MatchString(name) {
+			return []string{fmt.Sprintf("invalid STOPSIGNAL %q: signal names must start with SIG, e.g. SIG%s", signal, name)}
+		}
+		return []string{fmt.Sprintf("invalid STOPSIGNAL %q: must be a signal number or a name like SIGTERM", signal)}
+	}
+	if _, ok := signalNames[name[3:]]; !ok && !realtimeSignal.MatchString(name[3:]) {
+		return []string{fmt.Sprintf("invalid STOPSIGNAL %q: unknown signal", signal)}
+	}
+	return nil
+}
+
+func validatePortSpec(spec string) error {
+	ports := spec
+	if i := strings.Index(spec, "/"); i != -1 {
+		ports = spec[:i]
+		switch proto := strings.ToLower(spec[i+1:]); proto {
+		case "tcp", "udp", "sctp":
+		default:
+			return fmt.Errorf("unknown protocol %q", proto)
+		}
+	}
+
+	start, end := ports, ports
+	if i := strings.Index(ports, "-"); i != -1 {
+		start, end = ports[:i], ports[i+1:]
+	}
+	first, err := parsePort(start)
+	if err != nil {
+		return err
+	}
+	last, err := parsePort(end)
+	if err != nil {
+		return err
+	}
+	if last < first {
+		return fmt.Errorf("invalid port range %d-%d", first, last)
+	}
+	return nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("port must be a number between 1 and 65535")
+	}
+	return port, nil
+}
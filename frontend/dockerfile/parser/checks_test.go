@@ -0,0 +1,264 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestCheckExpose(t *testing.T) {
+	dockerfile := `FROM busybox
+EXPOSE 80 443/tcp 53/udp 8000-8010 9000-9010/tcp $PORT
+EXPOSE 99999/tcp
+EXPOSE abc 80/http 10-5
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(result.Warnings, 4))
+	assert.Check(t, is.Equal(`[WARNING]: line 3: invalid EXPOSE port "99999/tcp": port must be a number between 1 and 65535`, result.Warnings[0]))
+	assert.Check(t, is.Contains(result.Warnings[1], `line 4: invalid EXPOSE port "abc"`))
+	assert.Check(t, is.Contains(result.Warnings[2], `line 4: invalid EXPOSE port "80/http": unknown protocol "http"`))
+	assert.Check(t, is.Contains(result.Warnings[3], `line 4: invalid EXPOSE port "10-5": invalid port range 10-5`))
+
+	_, err = ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{Strict: true})
+	assert.Check(t, is.ErrorContains(err, `Dockerfile parse error line 3: invalid EXPOSE port "99999/tcp"`))
+}
+
+func TestCheckDeprecatedMaintainer(t *testing.T) {
+	dockerfile := `FROM busybox
+MAINTAINER Jane Doe <jane@example.com>
+RUN true
+maintainer john
+`
+	result, err := ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{Strict: true})
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{
+		`[WARNING]: line 2: MAINTAINER instruction is deprecated, use LABEL maintainer="name" instead`,
+		`[WARNING]: line 4: MAINTAINER instruction is deprecated, use LABEL maintainer="name" instead`,
+	}, result.Warnings))
+
+	result, err = ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{AllowMaintainer: true})
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(result.Warnings, 0))
+}
+
+func TestCheckUnknownInstruction(t *testing.T) {
+	dockerfile := `FROM busybox
+RUN true
+FOOBAR x y
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{"[WARNING]: line 3: unknown instruction: FOOBAR"}, result.Warnings))
+	assert.Check(t, is.Len(result.AST.Children, 3))
+
+	_, err = ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{Strict: true})
+	assert.Check(t, is.Error(err, "Dockerfile parse error line 3: unknown instruction: FOOBAR"))
+}
+
+func TestCheckHealthcheck(t *testing.T) {
+	dockerfile := `FROM busybox
+HEALTHCHECK --interval=30s --timeout=1m30s --start-period=5s --retries=3 CMD curl -f http://localhost/
+HEALTHCHECK NONE
+HEALTHCHECK --interval=banana CMD true
+HEALTHCHECK --timeout=30 CMD true
+HEALTHCHECK --start-period=-5s CMD true
+HEALTHCHECK --retries=0 --retries=x CMD true
+HEALTHCHECK --intervals=5s CMD true
+HEALTHCHECK NONE curl
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{
+		`[WARNING]: line 4: HEALTHCHECK --interval must be a duration like 30s or 1m30s, got "banana"`,
+		`[WARNING]: line 5: HEALTHCHECK --timeout must be a duration like 30s or 1m30s, got "30"`,
+		`[WARNING]: line 6: HEALTHCHECK --start-period can't be negative, got "-5s"`,
+		`[WARNING]: line 7: invalid HEALTHCHECK --retries "0": must be a positive integer`,
+		`[WARNING]: line 7: invalid HEALTHCHECK --retries "x": must be a positive integer`,
+		`[WARNING]: line 8: unknown HEALTHCHECK flag "--intervals=5s"`,
+		`[WARNING]: line 9: HEALTHCHECK NONE takes no arguments`,
+	}, result.Warnings))
+
+	_, err = ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{Strict: true})
+	assert.Check(t, is.ErrorContains(err, `Dockerfile parse error line 4: HEALTHCHECK --interval must be a duration`))
+}
+
+func TestCheckShell(t *testing.T) {
+	dockerfile := `FROM busybox
+SHELL ["powershell", "-command"]
+SHELL powershell -command
+SHELL []
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{
+		`[WARNING]: line 3: SHELL requires the arguments to be in JSON form, e.g. SHELL ["powershell", "-command"]`,
+		`[WARNING]: line 4: SHELL requires the arguments to be in JSON form, e.g. SHELL ["powershell", "-command"]`,
+	}, result.Warnings))
+
+	shell := result.AST.Children[1]
+	assert.Check(t, is.Equal("powershell", shell.Next.Value))
+	assert.Check(t, is.Equal("-command", shell.Next.Next.Value))
+
+	_, err = ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{Strict: true})
+	assert.Check(t, is.ErrorContains(err, "Dockerfile parse error line 3: SHELL requires the arguments to be in JSON form"))
+}
+
+func TestWhitespaceSeparators(t *testing.T) {
+	dockerfile := "FROM\tbusybox AS\tbase\n" +
+		"EXPOSE 80\t443 \t 8080\n" +
+		"ENV\ta=1\tb=2 c=3\n" +
+		"RUN foo bar\n" +
+		"LABEL --x=Å a=b c\n"
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(result.AST.Children, 5))
+
+	assert.Check(t, is.DeepEqual([]string{"busybox", "AS", "base"}, nodeValues(result.AST.Children[0].Next)))
+	assert.Check(t, is.DeepEqual([]string{"80", "443", "8080"}, nodeValues(result.AST.Children[1].Next)))
+	assert.Check(t, is.DeepEqual([]string{"a", "1", "b", "2", "c", "3"}, nodeValues(result.AST.Children[2].Next)))
+
+	run := result.AST.Children[3]
+	assert.Check(t, is.DeepEqual([]string{"foo bar"}, nodeValues(run.Next)))
+
+	label := result.AST.Children[4]
+	assert.Check(t, is.DeepEqual([]string{"--x=Å"}, label.Flags))
+	assert.Check(t, is.DeepEqual([]string{"a", "b c"}, nodeValues(label.Next)))
+
+	assert.Check(t, is.DeepEqual([]string{
+		"[WARNING]: line 4: instruction contains the space character U+00A0, which doesn't separate arguments",
+		"[WARNING]: line 5: instruction contains the space character U+00A0, which doesn't separate arguments",
+	}, result.Warnings))
+}
+
+func TestCheckEnv(t *testing.T) {
+	dockerfile := `FROM busybox
+ENV a b=c
+ENV PATH /usr/local/bin:$PATH
+ENV a=b c=d
+ENV MSG hello world
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{
+		`[WARNING]: line 2: ENV mixes the legacy "ENV key value" form with the key=value form, a is set to "b=c"`,
+	}, result.Warnings))
+
+	_, err = Parse(strings.NewReader("FROM busybox\nENV a=b c\n"))
+	assert.Check(t, is.ErrorContains(err, `line 2: Syntax error - can't find = in "c"`))
+
+	_, err = Parse(strings.NewReader("FROM busybox\nENV PATH\n"))
+	assert.Check(t, is.Error(err, "Dockerfile parse error line 2: ENV must have two arguments"))
+}
+
+func TestDisallowLegacyEnv(t *testing.T) {
+	opts := ParseOptions{DisallowLegacyEnv: true}
+	_, err := ParseWithOptions(strings.NewReader("FROM busybox\nENV a b\n"), opts)
+	assert.Check(t, is.Error(err, `Dockerfile parse error line 2: ENV must be of the form ENV key=value, the legacy "ENV key value" form is not allowed`))
+
+	_, err = ParseWithOptions(strings.NewReader("FROM busybox\nENV a \\\n  b c\n"), opts)
+	assert.Check(t, is.ErrorContains(err, "line 2: ENV must be of the form ENV key=value"))
+
+	for _, dockerfile := range []string{
+		"FROM busybox\nENV a=b c=d\n",
+		"FROM busybox\nENV a=\"b c\"\n",
+		"FROM busybox\nLABEL a b\n",
+	} {
+		result, err := ParseWithOptions(strings.NewReader(dockerfile), opts)
+		assert.NilError(t, err, dockerfile)
+		assert.Check(t, is.Len(result.AST.Children, 2), dockerfile)
+	}
+
+	result, err := Parse(strings.NewReader("FROM busybox\nENV a b\nENV a=b c=d\n"))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{"a", "b"}, nodeValues(result.AST.Children[1].Next)))
+	assert.Check(t, is.DeepEqual([]string{"a", "b", "c", "d"}, nodeValues(result.AST.Children[2].Next)))
+}
+
+func TestCheckLabel(t *testing.T) {
+	dockerfile := `FROM busybox
+LABEL "com.example.desc"="a long value" \
+      com.example.version=1.0 \
+      "com.example.empty"=""
+LABEL maintainer foo@bar.com
+LABEL a b=c
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{
+		`[WARNING]: line 6: LABEL mixes the legacy "LABEL key value" form with the key=value form, a is set to "b=c"`,
+	}, result.Warnings))
+
+	labels := result.AST.Children[1]
+	assert.Check(t, is.Equal(2, labels.StartLine))
+	assert.Check(t, is.Equal(4, labels.EndLine()))
+	assert.Check(t, is.DeepEqual([]string{
+		`"com.example.desc"`, `"a long value"`,
+		"com.example.version", "1.0",
+		`"com.example.empty"`, `""`,
+	}, nodeValues(labels.Next)))
+	assert.Check(t, is.DeepEqual([]string{"maintainer", "foo@bar.com"}, nodeValues(result.AST.Children[2].Next)))
+
+	_, err = Parse(strings.NewReader("FROM busybox\nLABEL foo\n"))
+	assert.Check(t, is.Error(err, "Dockerfile parse error line 2: LABEL must have two arguments"))
+}
+
+func TestCheckOnbuild(t *testing.T) {
+	dockerfile := `FROM busybox
+ONBUILD ONBUILD RUN x
+ONBUILD FROM alpine
+ONBUILD MAINTAINER me
+ONBUILD FOO bar
+ONBUILD EXPOSE 80/tcpx
+ONBUILD RUN make
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{
+		"[WARNING]: line 2: ONBUILD isn't allowed as an ONBUILD trigger",
+		"[WARNING]: line 3: FROM isn't allowed as an ONBUILD trigger",
+		"[WARNING]: line 4: MAINTAINER isn't allowed as an ONBUILD trigger",
+		"[WARNING]: line 5: unknown instruction in ONBUILD: FOO",
+		`[WARNING]: line 6: invalid EXPOSE port "80/tcpx": unknown protocol "tcpx"`,
+	}, result.Warnings))
+
+	// the trigger is still parsed
+	nested := result.AST.Children[1].Next.Children[0]
+	assert.Check(t, is.Equal("onbuild", nested.Value))
+	assert.Check(t, is.Equal("run", nested.Next.Children[0].Value))
+	assert.Check(t, is.Equal("from", result.AST.Children[2].Next.Children[0].Value))
+
+	_, err = ParseWithOptions(strings.NewReader("FROM busybox\nONBUILD FROM alpine\n"), ParseOptions{Strict: true})
+	assert.Check(t, is.Error(err, "Dockerfile parse error line 2: FROM isn't allowed as an ONBUILD trigger"))
+}
+
+func TestCheckStopSignal(t *testing.T) {
+	dockerfile := `FROM busybox
+STOPSIGNAL 9
+STOPSIGNAL SIGKILL
+STOPSIGNAL SIGRTMIN+3
+STOPSIGNAL $STOP
+STOPSIGNAL KILL
+STOPSIGNAL SIGFOO
+STOPSIGNAL foo
+STOPSIGNAL 0
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{
+		`[WARNING]: line 6: invalid STOPSIGNAL "KILL": signal names must start with SIG, e.g. SIGKILL`,
+		`[WARNING]: line 7: invalid STOPSIGNAL "SIGFOO": unknown signal`,
+		`[WARNING]: line 8: invalid STOPSIGNAL "foo": must be a signal number or a name like SIGTERM`,
+		`[WARNING]: line 9: invalid STOPSIGNAL "0": signal numbers must be positive`,
+	}, result.Warnings))
+	assert.Check(t, is.Equal(RuleInvalidStopSignal, result.StructuredWarnings[0].RuleID))
+
+	result, err = Parse(strings.NewReader("# check=skip=InvalidStopSignal\n" + dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(result.Warnings, 0))
+
+	_, err = ParseWithOptions(strings.NewReader("# check=skip=InvalidStopSignal\nFROM busybox\nSTOPSIGNAL KILL\n"), ParseOptions{Strict: true})
+	assert.NilError(t, err)
+}
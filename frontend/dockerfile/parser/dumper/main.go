@@ -8,22 +8,13 @@ import (
 )
 
 func main() {
-	var f *os.File
-	var err error
-
 	if len(os.Args) < 2 {
 		fmt.Println("please supply filename(s)")
 		os.Exit(1)
 	}
 
 	for _, fn := range os.Args[1:] {
-		f, err = os.Open(fn)
-		if err != nil {
-			panic(err)
-		}
-		defer f.Close()
-
-		result, err := parser.Parse(f)
+		result, err := parser.ParseFile(fn)
 		if err != nil {
 			panic(err)
 		}
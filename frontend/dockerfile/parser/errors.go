@@ -0,0 +1,54 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoInstructions is returned when a Dockerfile has no instructions, e.g.
+// because it is empty or only holds blank lines.
+var ErrNoInstructions = errors.New("file with no instructions.")
+
+// ErrOnlyComments is returned when a Dockerfile has comments or parser
+// directives but no instructions. It has the same message as, and matches,
+// ErrNoInstructions.
+var ErrOnlyComments = fmt.Errorf("%w", ErrNoInstructions)
+
+// ParseError is returned when a Dockerfile can't be parsed. It records the
+// line the error was found on and wraps the underlying error.
+type ParseError struct {
+	Line    int    // the line in the original dockerfile where the error was found
+	Message string // description of the error
+	Err     error  // the underlying error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("Dockerfile parse error line %d: %s", e.Line, e.Message)
+}
+
+// Unwrap returns the underlying error
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Cause returns the underlying error, for use with github.com/pkg/errors
+func (e *ParseError) Cause() error {
+	return e.Err
+}
+
+func newParseError(line int, err error) *ParseError {
+	return &ParseError{Line: line, Message: err.Error(), Err: err}
+}
+
+// ParseErrors is returned when ParseOptions.CollectErrors is set and one or
+// more instructions could not be parsed.
+type ParseErrors []ParseError
+
+func (e ParseErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i := range e {
+		msgs[i] = e[i].Error()
+	}
+	return strings.Join(msgs, "\n")
+}
@@ -0,0 +1,103 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestParseErrorLine(t *testing.T) {
+	dockerfile := `FROM busybox
+
+RUN echo hello
+
+ENV foo
+`
+	_, err := Parse(strings.NewReader(dockerfile))
+	assert.Check(t, is.Error(err, "Dockerfile parse error line 5: ENV must have two arguments"))
+
+	var perr *ParseError
+	assert.Assert(t, errors.As(err, &perr))
+	assert.Check(t, is.Equal(5, perr.Line))
+	assert.Check(t, is.Equal("ENV must have two arguments", perr.Message))
+	assert.Check(t, is.Equal(perr.Err, errors.Unwrap(err)))
+}
+
+func TestParseErrorDirectiveLine(t *testing.T) {
+	_, err := Parse(strings.NewReader("# escape=x\nFROM busybox\n"))
+
+	var perr *ParseError
+	assert.Assert(t, errors.As(err, &perr))
+	assert.Check(t, is.Equal(1, perr.Line))
+	assert.Check(t, is.ErrorContains(err, "invalid ESCAPE 'x'"))
+}
+
+func TestParseErrorDuplicateEscapeDirective(t *testing.T) {
+	dockerfile := "# escape=`\n# escape=\\\nFROM busybox\nRUN echo `\n  foo\n"
+	_, err := Parse(strings.NewReader(dockerfile))
+
+	var perr *ParseError
+	assert.Assert(t, errors.As(err, &perr))
+	assert.Check(t, is.Equal(2, perr.Line))
+	assert.Check(t, is.Equal("only one escape parser directive can be used", perr.Message))
+
+	result, err := ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{CollectErrors: true})
+	var errs ParseErrors
+	assert.Assert(t, errors.As(err, &errs))
+	assert.Assert(t, is.Len(errs, 1))
+	assert.Check(t, is.Equal(2, errs[0].Line))
+
+	// the first directive still applies
+	assert.Check(t, is.Equal('`', result.EscapeToken))
+	assert.Assert(t, is.Len(result.AST.Children, 2))
+	assert.Check(t, is.Equal("echo   foo", result.AST.Children[1].Next.Value))
+}
+
+func TestParseCollectErrors(t *testing.T) {
+	dockerfile := `FROM busybox
+ENV foo
+RUN echo hello
+LABEL a=b c
+CMD ["echo"]
+`
+	result, err := ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{CollectErrors: true})
+	assert.Assert(t, result != nil)
+	assert.Check(t, is.Len(result.AST.Children, 3))
+
+	var errs ParseErrors
+	assert.Assert(t, errors.As(err, &errs))
+	assert.Assert(t, is.Len(errs, 2))
+	assert.Check(t, is.Equal(2, errs[0].Line))
+	assert.Check(t, is.Equal(4, errs[1].Line))
+	assert.Check(t, is.ErrorContains(err, "Dockerfile parse error line 2: ENV must have two arguments"))
+	assert.Check(t, is.ErrorContains(err, "Dockerfile parse error line 4: Syntax error - can't find ="))
+
+	_, err = Parse(strings.NewReader(dockerfile))
+	assert.Check(t, is.Error(err, "Dockerfile parse error line 2: ENV must have two arguments"))
+}
+
+func TestErrNoInstructions(t *testing.T) {
+	for name, dockerfile := range map[string]string{
+		"empty":    "",
+		"blank":    "\n  \n\t\n",
+		"bom only": "\xef\xbb\xbf\n\n",
+	} {
+		_, err := Parse(strings.NewReader(dockerfile))
+		assert.Check(t, errors.Is(err, ErrNoInstructions), name)
+		assert.Check(t, !errors.Is(err, ErrOnlyComments), name)
+		assert.Check(t, is.Error(err, "file with no instructions."), name)
+	}
+
+	for name, dockerfile := range map[string]string{
+		"comments":   "# a comment\n\n# another one\n",
+		"directives": "\xef\xbb\xbf# syntax=docker/dockerfile:1\n",
+	} {
+		_, err := Parse(strings.NewReader(dockerfile))
+		assert.Check(t, errors.Is(err, ErrOnlyComments), name)
+		assert.Check(t, errors.Is(err, ErrNoInstructions), name)
+		assert.Check(t, is.Error(err, "file with no instructions."), name)
+	}
+}
@@ -0,0 +1,413 @@
+package parser
+
+import (
+	"encoding/csv"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/moby/buildkit/frontend/dockerfile/command"
+	"github.com/pkg/errors"
+)
+
+// mountTypes are the values accepted for the type key of a RUN --mount flag.
+var mountTypes = map[string]struct{}{
+	"bind":   {},
+	"cache":  {},
+	"secret": {},
+	"ssh":    {},
+	"tmpfs":  {},
+}
+
+// networkModes are the values accepted by RUN --network
+var networkModes = []string{"default", "none", "host"}
+
+// securityModes are the values accepted by RUN --security
+var securityModes = []string{"insecure", "sandbox"}
+
+// chmodPattern matches file modes accepted by --chmod
+var chmodPattern = regexp.MustCompile(`^[0-7]{3,4}$`)
+
+// chownPattern matches a user or group name or id accepted by --chown
+var chownPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*\$?$`)
+
+// checksumLengths are the lengths of the hex digests of the algorithms
+// accepted by ADD --checksum
+var checksumLengths = map[string]int{
+	"sha256": 64,
+	"sha512": 128,
+}
+
+// hexDigest matches a lowercase or uppercase hex string
+var hexDigest = regexp.MustCompile(`^[a-fA-F0-9]+$`)
+
+// platformComponent matches a single os, architecture or variant of a
+// platform.
+var platformComponent = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// flagValues returns the values of every occurrence of the builder flag
+// `--name` in flags, in order. A flag given without a value yields "".
+func flagValues(flags []string, name string) []string {
+	var values []string
+	for _, flag := range flags {
+		if !strings.HasPrefix(flag, "--") {
+			continue
+		}
+		parts := strings.SplitN(flag[2:], "=", 2)
+		if parts[0] != name {
+			continue
+		}
+		if len(parts) == 1 {
+			values = append(values, "")
+		} else {
+			values = append(values, parts[1])
+		}
+	}
+	return values
+}
+
+// GetFlag returns the value of the builder flag `--name` of the node. If
+// the flag is given more than once the last value wins, as it does for the
+// instructions. A flag given without a value yields "".
+func (node *Node) GetFlag(name string) (value string, ok bool) {
+	values := flagValues(node.Flags, name)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// SetFlag sets the builder flag `--name` of the node to value, replacing
+// every existing occurrence of it in the position of the first one, or
+// appending it. The order of the other flags is preserved. An empty value
+// sets the flag without a value, e.g. `--link`.
+func (node *Node) SetFlag(name, value string) {
+	flag := "--" + name
+	if value != "" {
+		flag += "=" + value
+	}
+	var flags []string
+	found := false
+	for _, f := range node.Flags {
+		if len(flagValues([]string{f}, name)) == 0 {
+			flags = append(flags, f)
+			continue
+		}
+		if !found {
+			flags = append(flags, flag)
+			found = true
+		}
+	}
+	if !found {
+		flags = append(flags, flag)
+	}
+	node.Flags = flags
+}
+
+// ParseMount parses the value of a RUN --mount flag, such as
+// `type=cache,target=/root/.cache`, into its key/value pairs. The `--mount=`
+// prefix is optional. Keys are lowercased, options given without a value
+// (like `ro`) are set to "true" and the type defaults to "bind".
+func ParseMount(flag string) (map[string]string, error) {
+	value := strings.TrimPrefix(flag, "--mount=")
+	fields, err := csv.NewReader(strings.NewReader(value)).Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse mount")
+	}
+
+	mount := map[string]string{"type": "bind"}
+	for _, field := range fields {
+		parts := strings.SplitN(field, "=", 2)
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		if key == "" {
+			return nil, errors.Errorf("invalid field %q in mount", field)
+		}
+		if len(parts) == 1 {
+			mount[key] = "true"
+			continue
+		}
+		mount[key] = parts[1]
+	}
+
+	mount["type"] = strings.ToLower(mount["type"])
+	if _, ok := mountTypes[mount["type"]]; !ok {
+		return nil, errors.Errorf("unsupported mount type %q", mount["type"])
+	}
+	return mount, nil
+}
+
+// RunMounts returns the parsed value of every --mount flag of a RUN
+// instruction, in the order they were given.
+func RunMounts(node *Node) ([]map[string]string, error) {
+	if !strings.EqualFold(node.Value, command.Run) {
+		return nil, errors.Errorf("%s instruction does not support --mount", strings.ToUpper(node.Value))
+	}
+	var mounts []map[string]string
+	for _, value := range flagValues(node.Flags, "mount") {
+		m, err := ParseMount(value)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+// RunNetwork returns the --network flag of a RUN instruction, one of
+// default, none or host, or "" if it has none.
+func RunNetwork(node *Node) (string, error) {
+	return runModeFlag(node, "network", networkModes)
+}
+
+// RunSecurity returns the --security flag of a RUN instruction, insecure or
+// sandbox, or "" if it has none.
+func RunSecurity(node *Node) (string, error) {
+	return runModeFlag(node, "security", securityModes)
+}
+
+// runModeFlag returns the last value of a RUN flag that must be one of
+// modes. Values referencing build arguments are passed through without
+// validation.
+func runModeFlag(node *Node, name string, modes []string) (string, error) {
+	if !strings.EqualFold(node.Value, command.Run) {
+		return "", errors.Errorf("%s instruction does not support --%s", strings.ToUpper(node.Value), name)
+	}
+	values := flagValues(node.Flags, name)
+	if len(values) == 0 {
+		return "", nil
+	}
+	value := values[len(values)-1]
+	if strings.Contains(value, "$") {
+		return value, nil
+	}
+	for _, mode := range modes {
+		if value == mode {
+			return value, nil
+		}
+	}
+	return "", errors.Errorf("--%s must be one of %s, got %q", name, strings.Join(modes, ", "), value)
+}
+
+// Platform is the value of a FROM --platform flag.
+type Platform struct {
+	OS           string
+	Architecture string
+	Variant      string
+	// Raw is the value as written. When it references a build argument,
+	// e.g. $BUILDPLATFORM, it can only be resolved by the frontend and only
+	// Raw is set.
+	Raw string
+}
+
+// ParsePlatform parses a platform of the form os/arch[/variant]. Values
+// referencing build arguments are passed through without validation.
+func ParsePlatform(value string) (*Platform, error) {
+	p := &Platform{Raw: value}
+	if strings.Contains(value, "$") {
+		return p, nil
+	}
+	parts := strings.Split(value, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, errors.Errorf("platform %q must be of the form os/arch[/variant]", value)
+	}
+	for _, part := range parts {
+		if !platformComponent.MatchString(part) {
+			return nil, errors.Errorf("platform %q must be of the form os/arch[/variant]", value)
+		}
+	}
+	p.OS, p.Architecture = parts[0], parts[1]
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// FromPlatform returns the parsed --platform flag of a FROM instruction, or
+// nil if it has none.
+func FromPlatform(node *Node) (*Platform, error) {
+	if !strings.EqualFold(node.Value, command.From) {
+		return nil, errors.Errorf("%s instruction does not support --platform", strings.ToUpper(node.Value))
+	}
+	values := flagValues(node.Flags, "platform")
+	if len(values) == 0 {
+		return nil, nil
+	}
+	return ParsePlatform(values[len(values)-1])
+}
+
+// Chown is the value of a COPY or ADD --chown flag.
+type Chown struct {
+	User  string // user name or id
+	Group string // group name or id, if given
+}
+
+// ParseChown parses a --chown value of the form user[:group], where user and
+// group are names or numeric ids. Values referencing build arguments are
+// split but not validated.
+func ParseChown(value string) (*Chown, error) {
+	parts := strings.SplitN(value, ":", 2)
+	c := &Chown{User: parts[0]}
+	if len(parts) == 2 {
+		c.Group = parts[1]
+	}
+	if strings.Contains(value, "$") {
+		return c, nil
+	}
+	if !chownPattern.MatchString(c.User) || (len(parts) == 2 && !chownPattern.MatchString(c.Group)) {
+		return nil, errors.Errorf("chown %q must be of the form user[:group]", value)
+	}
+	return c, nil
+}
+
+// Chmod is the value of a COPY or ADD --chmod flag.
+type Chmod struct {
+	Mode uint32 // the parsed mode, unset if Raw references a build argument
+	Raw  string // the value as written
+}
+
+// ParseChmod parses a --chmod value, which must be an octal mode of 3 or 4
+// digits. Values referencing build arguments are passed through without
+// validation.
+func ParseChmod(value string) (*Chmod, error) {
+	c := &Chmod{Raw: value}
+	if strings.Contains(value, "$") {
+		return c, nil
+	}
+	if !chmodPattern.MatchString(value) {
+		return nil, errors.Errorf("chmod %q must be an octal mode of 3 or 4 digits", value)
+	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid chmod %q", value)
+	}
+	c.Mode = uint32(mode)
+	return c, nil
+}
+
+// CopyChown returns the parsed --chown flag of a COPY or ADD instruction, or
+// nil if it has none.
+func CopyChown(node *Node) (*Chown, error) {
+	values, err := copyFlagValues(node, "chown")
+	if err != nil || len(values) == 0 {
+		return nil, err
+	}
+	return ParseChown(values[len(values)-1])
+}
+
+// CopyChmod returns the parsed --chmod flag of a COPY or ADD instruction, or
+// nil if it has none.
+func CopyChmod(node *Node) (*Chmod, error) {
+	values, err := copyFlagValues(node, "chmod")
+	if err != nil || len(values) == 0 {
+		return nil, err
+	}
+	return ParseChmod(values[len(values)-1])
+}
+
+// CopyLink reports whether a COPY or ADD instruction has the --link flag,
+// which copies the files into a layer of their own. The flag takes no value,
+// but like other boolean flags it can be given as --link=true or
+// --link=false.
+func CopyLink(node *Node) (bool, error) {
+	values, err := copyFlagValues(node, "link")
+	if err != nil || len(values) == 0 {
+		return false, err
+	}
+	return parseBoolFlag("link", values[len(values)-1])
+}
+
+// CopyPaths splits the arguments of a COPY or ADD instruction, in shell or
+// JSON form, into the sources and the destination, which is the last
+// argument. ok is false if the node isn't a COPY or ADD instruction or has
+// fewer than two arguments.
+func CopyPaths(node *Node) (sources []string, dest string, ok bool) {
+	if !strings.EqualFold(node.Value, command.Copy) && !strings.EqualFold(node.Value, command.Add) {
+		return nil, "", false
+	}
+	var args []string
+	for n := node.Next; n != nil; n = n.Next {
+		args = append(args, n.Value)
+	}
+	if len(args) < 2 {
+		return nil, "", false
+	}
+	return args[:len(args)-1], args[len(args)-1], true
+}
+
+// parseBoolFlag parses the value of a boolean flag, where no value means
+// true
+func parseBoolFlag(name, value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "", "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, errors.Errorf("--%s takes no value, got %q", name, value)
+}
+
+// parseDurationFlag parses the value of a flag taking a duration with a
+// unit, e.g. 30s or 1m30s, which can't be negative. Values referencing build
+// arguments are not validated and yield 0.
+func parseDurationFlag(name, value string) (time.Duration, error) {
+	if strings.Contains(value, "$") {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.Errorf("--%s must be a duration like 30s or 1m30s, got %q", name, value)
+	}
+	if d < 0 {
+		return 0, errors.Errorf("--%s can't be negative, got %q", name, value)
+	}
+	return d, nil
+}
+
+func copyFlagValues(node *Node, name string) ([]string, error) {
+	if !strings.EqualFold(node.Value, command.Copy) && !strings.EqualFold(node.Value, command.Add) {
+		return nil, errors.Errorf("%s instruction does not support --%s", strings.ToUpper(node.Value), name)
+	}
+	return flagValues(node.Flags, name), nil
+}
+
+// Checksum is the value of an ADD --checksum flag.
+type Checksum struct {
+	Algorithm string // e.g. "sha256"
+	Digest    string // the hex encoded digest
+}
+
+// ParseChecksum parses a --checksum value of the form algorithm:digest.
+// The algorithm must be sha256 or sha512 and the length of the digest must
+// match it. Digests referencing build arguments are not validated.
+func ParseChecksum(value string) (*Checksum, error) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.Errorf("checksum %q must be of the form algorithm:digest", value)
+	}
+	c := &Checksum{Algorithm: parts[0], Digest: parts[1]}
+	length, ok := checksumLengths[c.Algorithm]
+	if !ok {
+		return nil, errors.Errorf("unsupported checksum algorithm %q", c.Algorithm)
+	}
+	if strings.Contains(c.Digest, "$") {
+		return c, nil
+	}
+	if !hexDigest.MatchString(c.Digest) || len(c.Digest) != length {
+		return nil, errors.Errorf("%s checksum must be %d hex characters, got %q", c.Algorithm, length, c.Digest)
+	}
+	return c, nil
+}
+
+// AddChecksum returns the parsed --checksum flag of an ADD instruction, or
+// nil if it has none.
+func AddChecksum(node *Node) (*Checksum, error) {
+	if !strings.EqualFold(node.Value, command.Add) {
+		return nil, errors.Errorf("%s instruction does not support --checksum", strings.ToUpper(node.Value))
+	}
+	values := flagValues(node.Flags, "checksum")
+	if len(values) == 0 {
+		return nil, nil
+	}
+	return ParseChecksum(values[len(values)-1])
+}
@@ -0,0 +1,327 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestGetFlag(t *testing.T) {
+	node := &Node{Value: "copy", Flags: []string{"--from=build", "--link", "--chmod=644", "--chmod=755"}}
+
+	value, ok := node.GetFlag("from")
+	assert.Check(t, ok)
+	assert.Check(t, is.Equal("build", value))
+
+	value, ok = node.GetFlag("chmod")
+	assert.Check(t, ok)
+	assert.Check(t, is.Equal("755", value))
+
+	value, ok = node.GetFlag("link")
+	assert.Check(t, ok)
+	assert.Check(t, is.Equal("", value))
+
+	_, ok = node.GetFlag("chown")
+	assert.Check(t, !ok)
+}
+
+func TestSetFlag(t *testing.T) {
+	node := &Node{Value: "copy", Flags: []string{"--chmod=644", "--from=build", "--chmod=600", "--link"}}
+
+	node.SetFlag("from", "base")
+	assert.Check(t, is.DeepEqual([]string{"--chmod=644", "--from=base", "--chmod=600", "--link"}, node.Flags))
+
+	node.SetFlag("chmod", "755")
+	assert.Check(t, is.DeepEqual([]string{"--chmod=755", "--from=base", "--link"}, node.Flags))
+
+	node.SetFlag("chown", "1000:1000")
+	assert.Check(t, is.DeepEqual([]string{"--chmod=755", "--from=base", "--link", "--chown=1000:1000"}, node.Flags))
+
+	from := &Node{Value: "from"}
+	from.SetFlag("platform", "linux/amd64")
+	assert.Check(t, is.DeepEqual([]string{"--platform=linux/amd64"}, from.Flags))
+}
+
+func TestParseMount(t *testing.T) {
+	m, err := ParseMount("--mount=type=cache,target=/root/.cache,ro")
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(map[string]string{"type": "cache", "target": "/root/.cache", "ro": "true"}, m))
+
+	m, err = ParseMount(`"source=a,b",Target=/src`)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(map[string]string{"type": "bind", "source": "a,b", "target": "/src"}, m))
+
+	_, err = ParseMount("type=volume,target=/data")
+	assert.Check(t, is.ErrorContains(err, `unsupported mount type "volume"`))
+}
+
+func TestRunMounts(t *testing.T) {
+	dockerfile := `FROM busybox
+RUN --mount=type=cache,target=/root/.cache --mount=type=secret,id=token go build
+RUN --mount=type=volume,target=/data true
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(result.Warnings, 1))
+	assert.Check(t, is.Equal(`[WARNING]: line 3: invalid --mount "type=volume,target=/data": unsupported mount type "volume"`, result.Warnings[0]))
+
+	mounts, err := RunMounts(result.AST.Children[1])
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]map[string]string{
+		{"type": "cache", "target": "/root/.cache"},
+		{"type": "secret", "id": "token"},
+	}, mounts))
+
+	_, err = RunMounts(result.AST.Children[2])
+	assert.Check(t, is.ErrorContains(err, "unsupported mount type"))
+
+	_, err = RunMounts(result.AST.Children[0])
+	assert.Check(t, is.ErrorContains(err, "FROM instruction does not support --mount"))
+}
+
+func TestRunNetworkAndSecurity(t *testing.T) {
+	dockerfile := `FROM busybox
+RUN --network=none --security=insecure --mount=type=cache,target=/root/.cache make
+RUN --network=host true
+RUN --network=bridge --security=privileged true
+RUN --network=$NETWORK true
+RUN true
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{
+		`[WARNING]: line 4: RUN --network must be one of default, none, host, got "bridge"`,
+		`[WARNING]: line 4: RUN --security must be one of insecure, sandbox, got "privileged"`,
+	}, result.Warnings))
+
+	run := result.AST.Children[1]
+	network, err := RunNetwork(run)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("none", network))
+	security, err := RunSecurity(run)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("insecure", security))
+	mounts, err := RunMounts(run)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(mounts, 1))
+
+	network, err = RunNetwork(result.AST.Children[2])
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("host", network))
+
+	_, err = RunNetwork(result.AST.Children[3])
+	assert.Check(t, is.Error(err, `--network must be one of default, none, host, got "bridge"`))
+
+	network, err = RunNetwork(result.AST.Children[4])
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("$NETWORK", network))
+
+	network, err = RunNetwork(result.AST.Children[5])
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("", network))
+
+	_, err = RunSecurity(result.AST.Children[0])
+	assert.Check(t, is.ErrorContains(err, "FROM instruction does not support --security"))
+}
+
+func TestFromPlatform(t *testing.T) {
+	dockerfile := `FROM --platform=linux/arm64 alpine
+FROM --platform=linux/arm/v7 alpine
+FROM --platform=$BUILDPLATFORM golang
+FROM alpine
+FROM --platform= alpine
+FROM --platform=linux alpine
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{
+		`[WARNING]: line 5: invalid --platform: platform "" must be of the form os/arch[/variant]`,
+		`[WARNING]: line 6: invalid --platform: platform "linux" must be of the form os/arch[/variant]`,
+	}, result.Warnings))
+
+	p, err := FromPlatform(result.AST.Children[0])
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(&Platform{OS: "linux", Architecture: "arm64", Raw: "linux/arm64"}, p))
+
+	p, err = FromPlatform(result.AST.Children[1])
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(&Platform{OS: "linux", Architecture: "arm", Variant: "v7", Raw: "linux/arm/v7"}, p))
+
+	p, err = FromPlatform(result.AST.Children[2])
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(&Platform{Raw: "$BUILDPLATFORM"}, p))
+
+	p, err = FromPlatform(result.AST.Children[3])
+	assert.NilError(t, err)
+	assert.Check(t, p == nil)
+
+	_, err = FromPlatform(result.AST.Children[4])
+	assert.Check(t, is.ErrorContains(err, "must be of the form os/arch[/variant]"))
+}
+
+func TestCopyLink(t *testing.T) {
+	dockerfile := `FROM busybox AS build
+COPY --link --from=build --chown=app a b
+ADD --link=false a b
+COPY --link=foo a b
+COPY a b
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{
+		`[WARNING]: line 4: invalid --link: --link takes no value, got "foo"`,
+	}, result.Warnings))
+
+	copyLink := result.AST.Children[1]
+	assert.Check(t, is.DeepEqual([]string{"--link", "--from=build", "--chown=app"}, copyLink.Flags))
+	link, err := CopyLink(copyLink)
+	assert.NilError(t, err)
+	assert.Check(t, link)
+	from, _ := copyLink.GetFlag("from")
+	assert.Check(t, is.Equal("build", from))
+
+	link, err = CopyLink(result.AST.Children[2])
+	assert.NilError(t, err)
+	assert.Check(t, !link)
+
+	_, err = CopyLink(result.AST.Children[3])
+	assert.Check(t, is.Error(err, `--link takes no value, got "foo"`))
+
+	link, err = CopyLink(result.AST.Children[4])
+	assert.NilError(t, err)
+	assert.Check(t, !link)
+
+	_, err = CopyLink(result.AST.Children[0])
+	assert.Check(t, is.ErrorContains(err, "FROM instruction does not support --link"))
+}
+
+func TestCopyChownChmod(t *testing.T) {
+	dockerfile := `FROM busybox
+COPY --chown=1000:1000 --chmod=755 src dst
+ADD --chown=nobody --chmod=0644 src dst
+COPY --chown=app:staff src dst
+COPY --chown=$UID:$GID --chmod=$MODE src dst
+COPY --chmod=999 src dst
+COPY --chown=app: src dst
+COPY src dst
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{
+		`[WARNING]: line 6: invalid --chmod: chmod "999" must be an octal mode of 3 or 4 digits`,
+		`[WARNING]: line 7: invalid --chown: chown "app:" must be of the form user[:group]`,
+	}, result.Warnings))
+
+	chown, err := CopyChown(result.AST.Children[1])
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(&Chown{User: "1000", Group: "1000"}, chown))
+	chmod, err := CopyChmod(result.AST.Children[1])
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(&Chmod{Mode: 0755, Raw: "755"}, chmod))
+
+	chown, err = CopyChown(result.AST.Children[2])
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(&Chown{User: "nobody"}, chown))
+	chmod, err = CopyChmod(result.AST.Children[2])
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(&Chmod{Mode: 0644, Raw: "0644"}, chmod))
+
+	chown, err = CopyChown(result.AST.Children[3])
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(&Chown{User: "app", Group: "staff"}, chown))
+
+	chown, err = CopyChown(result.AST.Children[4])
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(&Chown{User: "$UID", Group: "$GID"}, chown))
+	chmod, err = CopyChmod(result.AST.Children[4])
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(&Chmod{Raw: "$MODE"}, chmod))
+
+	_, err = CopyChmod(result.AST.Children[5])
+	assert.Check(t, is.ErrorContains(err, "must be an octal mode"))
+
+	chown, err = CopyChown(result.AST.Children[7])
+	assert.NilError(t, err)
+	assert.Check(t, chown == nil)
+
+	_, err = CopyChown(result.AST.Children[0])
+	assert.Check(t, is.ErrorContains(err, "FROM instruction does not support --chown"))
+}
+
+func TestAddChecksum(t *testing.T) {
+	const sha256 = "24454f830cdb571e2c4ad15481119c43b3cafd48dd869a9b2945d1036d1dc68d"
+	dockerfile := `FROM busybox
+ADD --checksum=sha256:` + sha256 + ` https://example.com/file.tar /
+ADD --checksum=sha256:abc123 https://example.com/file.tar /
+ADD --checksum=md5:abc123 https://example.com/file.tar /
+ADD --checksum=sha256:` + sha256 + ` file.tar /
+ADD https://example.com/file.tar /
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{
+		`[WARNING]: line 3: invalid --checksum: sha256 checksum must be 64 hex characters, got "abc123"`,
+		`[WARNING]: line 4: invalid --checksum: unsupported checksum algorithm "md5"`,
+		`[WARNING]: line 5: --checksum is only supported for remote sources, not "file.tar"`,
+	}, result.Warnings))
+
+	checksum, err := AddChecksum(result.AST.Children[1])
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(&Checksum{Algorithm: "sha256", Digest: sha256}, checksum))
+
+	_, err = AddChecksum(result.AST.Children[2])
+	assert.Check(t, is.ErrorContains(err, "sha256 checksum must be 64 hex characters"))
+
+	checksum, err = AddChecksum(result.AST.Children[5])
+	assert.NilError(t, err)
+	assert.Check(t, checksum == nil)
+}
+
+func TestCopyPaths(t *testing.T) {
+	dockerfile := `FROM busybox
+COPY --chown=app a b c /dst/
+ADD ["a b", "c", "/dst"]
+COPY a
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+
+	sources, dest, ok := CopyPaths(result.AST.Children[1])
+	assert.Check(t, ok)
+	assert.Check(t, is.DeepEqual([]string{"a", "b", "c"}, sources))
+	assert.Check(t, is.Equal("/dst/", dest))
+
+	sources, dest, ok = CopyPaths(result.AST.Children[2])
+	assert.Check(t, ok)
+	assert.Check(t, is.DeepEqual([]string{"a b", "c"}, sources))
+	assert.Check(t, is.Equal("/dst", dest))
+
+	_, _, ok = CopyPaths(result.AST.Children[3])
+	assert.Check(t, !ok)
+
+	_, _, ok = CopyPaths(result.AST.Children[0])
+	assert.Check(t, !ok)
+}
+
+func TestParseDurationFlag(t *testing.T) {
+	d, err := parseDurationFlag("interval", "30s")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(30*time.Second, d))
+
+	d, err = parseDurationFlag("timeout", "1m30s")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(90*time.Second, d))
+
+	d, err = parseDurationFlag("timeout", "$TIMEOUT")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(time.Duration(0), d))
+
+	_, err = parseDurationFlag("timeout", "30")
+	assert.Check(t, is.Error(err, `--timeout must be a duration like 30s or 1m30s, got "30"`))
+
+	_, err = parseDurationFlag("start-period", "-1s")
+	assert.Check(t, is.Error(err, `--start-period can't be negative, got "-1s"`))
+}
@@ -0,0 +1,94 @@
+package parser
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+)
+
+// Hash returns the hex encoded SHA-256 digest of a canonical serialization
+// of the AST, suitable as a cache key. Like Node.Equal it ignores line
+// numbers, Original, comments and raw lines, and it also ignores the casing
+// of the instructions and the runs of whitespace in shell-form arguments,
+// so that cosmetic edits to a Dockerfile leave the hash unchanged. The
+// syntax directive is included as it selects the frontend, and so is the
+// escape token, which changes how the builder expands the arguments. Blank
+// line and comment nodes kept in lossless mode are skipped.
+func (r *Result) Hash() string {
+	h := sha256.New()
+	writeHashString(h, r.Syntax)
+	escapeToken := r.EscapeToken
+	if escapeToken == 0 {
+		escapeToken = DefaultEscapeToken
+	}
+	writeHashString(h, string(escapeToken))
+	if r.AST != nil {
+		for _, child := range r.AST.Children {
+			if child.Value == BlankLineNode || child.Value == CommentNode {
+				continue
+			}
+			writeHashInstruction(h, child, escapeToken)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeHashString writes s prefixed by its length, so that the boundaries
+// between values can't be shifted without changing the hash.
+func writeHashString(h hash.Hash, s string) {
+	fmt.Fprintf(h, "%d:%s", len(s), s)
+}
+
+// writeHashInstruction writes the instruction with its keyword lowercased
+// and, like ParseOptions.CanonicalWhitespace, the runs of whitespace in its
+// shell-form arguments collapsed, followed by its arguments
+func writeHashInstruction(h hash.Hash, node *Node, escapeToken rune) {
+	writeHashValue(h, strings.ToLower(node.Value), node, escapeToken)
+	for n := node.Next; n != nil; n = n.Next {
+		value := n.Value
+		if !node.Attributes["json"] && len(n.Children) == 0 {
+			value = collapseWhitespace(value, escapeToken)
+		}
+		writeHashValue(h, value, n, escapeToken)
+	}
+	h.Write([]byte{0})
+}
+
+// writeHashValue writes a node of an instruction, with the given value, and
+// the instructions nested in it
+func writeHashValue(h hash.Hash, value string, node *Node, escapeToken rune) {
+	h.Write([]byte{1})
+	writeHashString(h, value)
+
+	fmt.Fprintf(h, "f%d", len(node.Flags))
+	for _, flag := range node.Flags {
+		writeHashString(h, flag)
+	}
+
+	var attrs []string
+	for k, v := range node.Attributes {
+		if v {
+			attrs = append(attrs, k)
+		}
+	}
+	sort.Strings(attrs)
+	fmt.Fprintf(h, "a%d", len(attrs))
+	for _, attr := range attrs {
+		writeHashString(h, attr)
+	}
+
+	fmt.Fprintf(h, "h%d", len(node.Heredocs))
+	for _, heredoc := range node.Heredocs {
+		writeHashString(h, heredoc.Name)
+		writeHashString(h, heredoc.Content)
+		fmt.Fprintf(h, "%t%t", heredoc.Chomp, heredoc.Expand)
+	}
+
+	fmt.Fprintf(h, "c%d", len(node.Children))
+	for _, child := range node.Children {
+		writeHashInstruction(h, child, escapeToken)
+	}
+}
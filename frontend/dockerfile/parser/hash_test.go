@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestResultHash(t *testing.T) {
+	parse := func(dockerfile string) *Result {
+		t.Helper()
+		result, err := Parse(strings.NewReader(dockerfile))
+		assert.NilError(t, err)
+		return result
+	}
+
+	original := parse(`FROM busybox AS build
+RUN echo hello && \
+    echo world
+COPY --from=build /a /b
+`)
+	reformatted := parse(`# build stage
+from   busybox   AS   build
+
+# greet
+run echo hello && \
+    echo world
+  COPY   --from=build /a   /b
+`)
+	changed := parse(`FROM busybox AS build
+RUN echo hello && \
+    echo there
+COPY --from=build /a /b
+`)
+
+	assert.Check(t, is.Len(original.Hash(), 64))
+	assert.Check(t, is.Equal(original.Hash(), reformatted.Hash()))
+	assert.Check(t, original.Hash() != changed.Hash())
+
+	// keyword casing and whitespace in shell-form arguments don't count,
+	// whitespace in quotes and JSON arrays does
+	spaced := parse("FROM busybox\nRUN   echo    hello  \nONBUILD RUN make   all\nCMD [\"a  b\"]\n")
+	assert.Check(t, is.Equal(parse("from busybox\nrun echo hello\nonbuild run make all\nCMD [\"a  b\"]\n").Hash(), spaced.Hash()))
+	assert.Check(t, spaced.Hash() != parse("FROM busybox\nRUN echo hello\nONBUILD RUN make all\nCMD [\"a b\"]\n").Hash())
+	assert.Check(t, parse("FROM busybox\nRUN echo \"a  b\"\n").Hash() != parse("FROM busybox\nRUN echo \"a b\"\n").Hash())
+
+	normalized, err := ParseWithOptions(strings.NewReader("from busybox\nrun echo hello\n"), ParseOptions{Normalize: true})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(parse("from busybox\nrun echo hello\n").Hash(), normalized.Hash()))
+
+	escaped := parse("FROM busybox\nENV x=\\$y\n")
+	backtick := parse("# escape=`\nFROM busybox\nENV x=\\$y\n")
+	assert.Check(t, escaped.AST.Equal(backtick.AST))
+	assert.Check(t, escaped.Hash() != backtick.Hash())
+	assert.Check(t, is.Equal(escaped.Hash(), parse("# escape=\\\nFROM busybox\nENV x=\\$y\n").Hash()))
+}
@@ -0,0 +1,84 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/command"
+	"github.com/pkg/errors"
+)
+
+// Heredoc is a here-document attached to an instruction, e.g.
+//
+//	RUN <<EOF
+//	echo hello
+//	EOF
+type Heredoc struct {
+	Name    string // the terminator, without quotes
+	Content string // the lines between the instruction and the terminator
+	Chomp   bool   // whether leading tabs are stripped, with <<-
+	Expand  bool   // whether variables in Content are expanded, i.e. Name wasn't quoted
+}
+
+// heredocCommands are the instructions that may be followed by heredocs
+var heredocCommands = map[string]struct{}{
+	command.Add:  {},
+	command.Copy: {},
+	command.Run:  {},
+}
+
+var reHeredoc = regexp.MustCompile(`^(\d*)<<(-?)(["']?)([^<"'\s]+)(["']?)$`)
+
+// heredocsFromLine returns the heredocs started on a logical line, in the
+// order their content follows it. The content is still empty.
+func heredocsFromLine(line string, d *Directive) []Heredoc {
+	cmd, _, args, err := splitCommand(line)
+	if err != nil {
+		return nil
+	}
+	if _, ok := heredocCommands[cmd]; !ok {
+		return nil
+	}
+
+	var heredocs []Heredoc
+	for _, word := range parseWords(args, d) {
+		match := reHeredoc.FindStringSubmatch(word)
+		if match == nil || match[3] != match[5] {
+			continue
+		}
+		heredocs = append(heredocs, Heredoc{
+			Name:   match[4],
+			Chomp:  match[2] == "-",
+			Expand: match[3] == "",
+		})
+	}
+	return heredocs
+}
+
+// readHeredocs reads the content of the heredocs from the scanner, up to
+// the terminator of the last one. It returns the number of lines consumed.
+func readHeredocs(scanner *offsetScanner, heredocs []Heredoc) (int, error) {
+	var lines int
+	for i := range heredocs {
+		h := &heredocs[i]
+		var content []string
+		terminated := false
+		for scanner.Scan() {
+			lines++
+			text := scanner.Text()
+			if h.Chomp {
+				text = strings.TrimLeft(text, "\t")
+			}
+			if text == h.Name {
+				terminated = true
+				break
+			}
+			content = append(content, text+"\n")
+		}
+		if !terminated {
+			return lines, errors.Errorf("unterminated heredoc %s", h.Name)
+		}
+		h.Content = strings.Join(content, "")
+	}
+	return lines, nil
+}
@@ -0,0 +1,73 @@
+package parser
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestParseHeredocs(t *testing.T) {
+	dockerfile := "FROM busybox\n" +
+		"RUN <<EOF\n" +
+		"echo hello\n" +
+		"echo world\n" +
+		"EOF\n" +
+		"COPY <<FILE1 <<\"FILE2\" /dest/\n" +
+		"contents of file1\n" +
+		"FILE1\n" +
+		"contents of file2\n" +
+		"FILE2\n" +
+		"RUN <<-EOF\n" +
+		"\techo tabs\n" +
+		"\tEOF\n" +
+		"RUN echo \"<<EOF\"\n"
+
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(result.AST.Children, 5))
+
+	run := result.AST.Children[1]
+	assert.Check(t, is.DeepEqual([]Heredoc{{Name: "EOF", Content: "echo hello\necho world\n", Expand: true}}, run.Heredocs))
+	assert.Check(t, is.DeepEqual([]int{2, 5}, []int{run.StartLine, run.EndLine()}))
+
+	cp := result.AST.Children[2]
+	assert.Check(t, is.DeepEqual([]Heredoc{
+		{Name: "FILE1", Content: "contents of file1\n", Expand: true},
+		{Name: "FILE2", Content: "contents of file2\n"},
+	}, cp.Heredocs))
+	assert.Check(t, is.DeepEqual([]int{6, 10}, []int{cp.StartLine, cp.EndLine()}))
+
+	chomp := result.AST.Children[3]
+	assert.Check(t, is.DeepEqual([]Heredoc{{Name: "EOF", Content: "echo tabs\n", Chomp: true, Expand: true}}, chomp.Heredocs))
+
+	quoted := result.AST.Children[4]
+	assert.Check(t, is.Len(quoted.Heredocs, 0))
+
+	buf := &bytes.Buffer{}
+	assert.NilError(t, result.Unparse(buf))
+	reparsed, err := Parse(buf)
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(cp.Heredocs, reparsed.AST.Children[2].Heredocs))
+}
+
+func TestParseHeredocExpand(t *testing.T) {
+	dockerfile := "FROM busybox\n" +
+		"RUN <<EOF\necho $HOME\nEOF\n" +
+		"RUN <<\"EOF\"\necho $HOME\nEOF\n" +
+		"RUN <<-'EOF'\n\techo $HOME\n\tEOF\n"
+
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(result.AST.Children, 4))
+	assert.Check(t, is.DeepEqual([]Heredoc{{Name: "EOF", Content: "echo $HOME\n", Expand: true}}, result.AST.Children[1].Heredocs))
+	assert.Check(t, is.DeepEqual([]Heredoc{{Name: "EOF", Content: "echo $HOME\n"}}, result.AST.Children[2].Heredocs))
+	assert.Check(t, is.DeepEqual([]Heredoc{{Name: "EOF", Content: "echo $HOME\n", Chomp: true}}, result.AST.Children[3].Heredocs))
+}
+
+func TestParseUnterminatedHeredoc(t *testing.T) {
+	_, err := Parse(strings.NewReader("FROM busybox\nRUN <<EOF\necho hello\n"))
+	assert.Check(t, is.Error(err, "Dockerfile parse error line 2: unterminated heredoc EOF"))
+}
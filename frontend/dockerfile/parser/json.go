@@ -0,0 +1,117 @@
+package parser
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// jsonNode is the JSON representation of a Node. The Next linked list is
+// flattened into an array so consumers don't have to chase pointers.
+type jsonNode struct {
+	Value      string
+	Next       []*jsonNode
+	Children   []*jsonNode
+	Attributes map[string]bool
+	Flags      []string
+	Heredocs   []Heredoc
+	Original   string
+	StartLine  int
+	EndLine    int
+}
+
+// MarshalJSON implements json.Marshaler. Each node is written with its Value,
+// Original, StartLine and EndLine. Attributes, Flags, Heredocs, Next and
+// Children are always emitted as (possibly empty) objects and arrays so that
+// the output of equivalent trees is stable.
+func (node *Node) MarshalJSON() ([]byte, error) {
+	j, err := newJSONNode(node, true, map[*Node]struct{}{})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(j)
+}
+
+func newJSONNode(node *Node, withNext bool, seen map[*Node]struct{}) (*jsonNode, error) {
+	if _, ok := seen[node]; ok {
+		return nil, errors.Errorf("cycle detected at node %q", node.Value)
+	}
+	seen[node] = struct{}{}
+
+	j := &jsonNode{
+		Value:      node.Value,
+		Next:       []*jsonNode{},
+		Children:   []*jsonNode{},
+		Attributes: map[string]bool{},
+		Flags:      []string{},
+		Heredocs:   []Heredoc{},
+		Original:   node.Original,
+		StartLine:  node.StartLine,
+		EndLine:    node.endLine,
+	}
+	for k, v := range node.Attributes {
+		j.Attributes[k] = v
+	}
+	j.Flags = append(j.Flags, node.Flags...)
+	j.Heredocs = append(j.Heredocs, node.Heredocs...)
+
+	for _, child := range node.Children {
+		c, err := newJSONNode(child, true, seen)
+		if err != nil {
+			return nil, err
+		}
+		j.Children = append(j.Children, c)
+	}
+
+	if withNext {
+		for n := node.Next; n != nil; n = n.Next {
+			next, err := newJSONNode(n, false, seen)
+			if err != nil {
+				return nil, err
+			}
+			j.Next = append(j.Next, next)
+		}
+	}
+	return j, nil
+}
+
+// jsonInstruction is the JSON representation of a top-level instruction
+// written by WriteJSONL
+type jsonInstruction struct {
+	Command   string
+	Flags     []string
+	Args      []string
+	StartLine int
+	EndLine   int
+}
+
+// WriteJSONL writes every top-level instruction as a compact JSON object on
+// its own line, e.g. for tools like grep and jq or for logs. It holds the
+// command, the flags, the values of the arguments and the lines the
+// instruction spans. Flags and Args are always emitted as arrays. The
+// trigger of an ONBUILD instruction is given as its one argument, as
+// written.
+func (r *Result) WriteJSONL(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, child := range r.AST.Children {
+		j := jsonInstruction{
+			Command:   child.Value,
+			Flags:     append([]string{}, child.Flags...),
+			Args:      []string{},
+			StartLine: child.StartLine,
+			EndLine:   child.endLine,
+		}
+		for _, n := range child.ArgNodes() {
+			if len(n.Children) > 0 {
+				j.Args = append(j.Args, n.Children[0].Original)
+				continue
+			}
+			j.Args = append(j.Args, n.Value)
+		}
+		if err := enc.Encode(j); err != nil {
+			return errors.Wrapf(err, "failed to write the instruction on line %d", child.StartLine)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestNodeMarshalJSON(t *testing.T) {
+	dockerfile := `FROM busybox
+RUN echo hello \
+    world
+CMD ["sh", "-c", "true"]
+ONBUILD RUN make
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+
+	dt, err := json.Marshal(result.AST)
+	assert.NilError(t, err)
+
+	var root jsonNode
+	assert.NilError(t, json.Unmarshal(dt, &root))
+	assert.Check(t, is.Equal(1, root.StartLine))
+	assert.Check(t, is.Equal(5, root.EndLine))
+	assert.Assert(t, is.Len(root.Children, 4))
+
+	run := root.Children[1]
+	assert.Check(t, is.Equal("run", run.Value))
+	assert.Check(t, is.Equal(2, run.StartLine))
+	assert.Check(t, is.Equal(3, run.EndLine))
+	assert.Check(t, is.DeepEqual(map[string]bool{}, run.Attributes))
+	assert.Check(t, is.DeepEqual([]string{}, run.Flags))
+
+	cmd := root.Children[2]
+	assert.Check(t, is.DeepEqual(map[string]bool{"json": true}, cmd.Attributes))
+	assert.Assert(t, is.Len(cmd.Next, 3))
+	assert.Check(t, is.Equal("sh", cmd.Next[0].Value))
+	assert.Check(t, is.Equal("true", cmd.Next[2].Value))
+
+	onbuild := root.Children[3]
+	assert.Assert(t, is.Len(onbuild.Next, 1))
+	assert.Assert(t, is.Len(onbuild.Next[0].Children, 1))
+	assert.Check(t, is.Equal("run", onbuild.Next[0].Children[0].Value))
+}
+
+func TestNodeMarshalJSONCycle(t *testing.T) {
+	node := &Node{Value: "run"}
+	node.Next = &Node{Value: "a", Next: node}
+
+	_, err := json.Marshal(node)
+	assert.Check(t, is.ErrorContains(err, "cycle detected"))
+}
+
+func TestResultWriteJSONL(t *testing.T) {
+	dockerfile := `FROM busybox AS build
+COPY --from=build --chown=1:1 a b /dst/
+RUN echo hello \
+    world
+ONBUILD RUN make
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+
+	buf := &bytes.Buffer{}
+	assert.NilError(t, result.WriteJSONL(buf))
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	assert.Assert(t, is.Len(lines, len(result.AST.Children)))
+
+	var instructions []jsonInstruction
+	for _, line := range lines {
+		var j jsonInstruction
+		assert.NilError(t, json.Unmarshal([]byte(line), &j), line)
+		instructions = append(instructions, j)
+	}
+	assert.Check(t, is.DeepEqual([]jsonInstruction{
+		{Command: "from", Flags: []string{}, Args: []string{"busybox", "AS", "build"}, StartLine: 1, EndLine: 1},
+		{Command: "copy", Flags: []string{"--from=build", "--chown=1:1"}, Args: []string{"a", "b", "/dst/"}, StartLine: 2, EndLine: 2},
+		{Command: "run", Flags: []string{}, Args: []string{"echo hello     world"}, StartLine: 3, EndLine: 4},
+		{Command: "onbuild", Flags: []string{}, Args: []string{"RUN make"}, StartLine: 5, EndLine: 5},
+	}, instructions))
+}
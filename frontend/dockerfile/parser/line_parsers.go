@@ -11,7 +11,6 @@ import (
 	"errors"
 	"fmt"
 	"strings"
-	"unicode"
 	"unicode/utf8"
 )
 
@@ -74,7 +73,7 @@ func parseWords(rest string, d *Directive) []string {
 			if pos == len(rest) { // end of input
 				break
 			}
-			if unicode.IsSpace(ch) { // skip spaces
+			if isWhitespace(ch) { // skip spaces
 				continue
 			}
 			phase = inWord // found it, fall through
@@ -86,7 +85,7 @@ func parseWords(rest string, d *Directive) []string {
 			break
 		}
 		if phase == inWord {
-			if unicode.IsSpace(ch) {
+			if isWhitespace(ch) {
 				phase = inSpaces
 				if blankOK || len(word) > 0 {
 					words = append(words, word)
@@ -153,7 +152,7 @@ func parseNameVal(rest string, key string, d *Directive) (*Node, error) {
 	if !strings.Contains(words[0], "=") {
 		parts := tokenWhitespace.Split(rest, 2)
 		if len(parts) < 2 {
-			return nil, fmt.Errorf(key + " must have two arguments")
+			return nil, fmt.Errorf("%s must have two arguments", key)
 		}
 		return newKeyValueNode(parts[0], parts[1]), nil
 	}
@@ -210,6 +209,10 @@ func parseLabel(rest string, d *Directive) (*Node, map[string]bool, error) {
 // In addition, a keyword definition alone is of the form `keyword` like `name1`
 // above. And the assignments `name2=` and `name3=""` are equivalent and
 // assign an empty value to the respective keywords.
+//
+// Every word becomes a node of its own, as written. The nodes of assignments
+// have the "hasDefault" attribute set, so that `name1` and `name2=` can be
+// told apart, and Node.ArgDefault splits them into the name and the value.
 func parseNameOrNameVal(rest string, d *Directive) (*Node, map[string]bool, error) {
 	words := parseWords(rest, d)
 	if len(words) == 0 {
@@ -223,6 +226,9 @@ func parseNameOrNameVal(rest string, d *Directive) (*Node, map[string]bool, erro
 	for i, word := range words {
 		node := &Node{}
 		node.Value = word
+		if strings.Contains(word, "=") {
+			node.Attributes = map[string]bool{"hasDefault": true}
+		}
 		if i == 0 {
 			rootnode = node
 		} else {
@@ -271,7 +277,7 @@ func parseString(rest string, d *Directive) (*Node, map[string]bool, error) {
 
 // parseJSON converts JSON arrays to an AST.
 func parseJSON(rest string, d *Directive) (*Node, map[string]bool, error) {
-	rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+	rest = strings.TrimLeft(rest, whitespace)
 	if !strings.HasPrefix(rest, "[") {
 		return nil, nil, fmt.Errorf(`Error parsing "%s" as a JSON array`, rest)
 	}
@@ -343,13 +349,13 @@ func parseHealthConfig(rest string, d *Directive) (*Node, map[string]bool, error
 	// Find end of first argument
 	var sep int
 	for ; sep < len(rest); sep++ {
-		if unicode.IsSpace(rune(rest[sep])) {
+		if isWhitespace(rune(rest[sep])) {
 			break
 		}
 	}
 	next := sep
 	for ; next < len(rest); next++ {
-		if !unicode.IsSpace(rune(rest[next])) {
+		if !isWhitespace(rune(rest[next])) {
 			break
 		}
 	}
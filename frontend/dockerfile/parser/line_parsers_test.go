@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -20,7 +21,7 @@ func TestParseNameValOldFormat(t *testing.T) {
 	assert.DeepEqual(t, expected, node, cmpNodeOpt)
 }
 
-var cmpNodeOpt = cmp.AllowUnexported(Node{})
+var cmpNodeOpt = cmp.AllowUnexported(Node{}, lineOffset{})
 
 func TestParseNameValNewFormat(t *testing.T) {
 	directive := Directive{}
@@ -49,3 +50,55 @@ func TestParseNameValWithoutVal(t *testing.T) {
 	_, err := parseNameVal("foo", "ENV", &directive)
 	assert.Check(t, is.ErrorContains(err, ""), "ENV must have two arguments")
 }
+
+func TestParseNameOrNameVal(t *testing.T) {
+	directive := NewDefaultDirective()
+	node, _, err := parseNameOrNameVal("NODEFAULT EMPTY= VERSION=1.0", directive)
+	assert.NilError(t, err)
+
+	expected := &Node{
+		Value: "NODEFAULT",
+		Next: &Node{
+			Value:      "EMPTY=",
+			Attributes: map[string]bool{"hasDefault": true},
+			Next: &Node{
+				Value:      "VERSION=1.0",
+				Attributes: map[string]bool{"hasDefault": true},
+			},
+		},
+	}
+	assert.DeepEqual(t, expected, node, cmpNodeOpt)
+
+	type split struct {
+		name, value string
+		hasDefault  bool
+	}
+	var splits []split
+	for n := node; n != nil; n = n.Next {
+		name, value, hasDefault := n.ArgDefault(0)
+		splits = append(splits, split{name, value, hasDefault})
+	}
+	assert.Check(t, is.DeepEqual([]split{
+		{"NODEFAULT", "", false},
+		{"EMPTY", "", true},
+		{"VERSION", "1.0", true},
+	}, splits, cmp.AllowUnexported(split{})))
+
+	node.SetArgDefault("2.0", 0)
+	assert.Check(t, is.Equal("NODEFAULT=2.0", node.Value))
+	assert.Check(t, node.Attributes["hasDefault"])
+
+	node.SetArgDefault(`a "b"`, 0)
+	assert.Check(t, is.Equal(`NODEFAULT="a \"b\""`, node.Value))
+	_, value, _ := node.ArgDefault(0)
+	assert.Check(t, is.Equal(`a "b"`, value))
+}
+
+func TestParseNameOrNameValDump(t *testing.T) {
+	split, err := Parse(strings.NewReader("ARG a b\n"))
+	assert.NilError(t, err)
+	assigned, err := Parse(strings.NewReader("ARG a=b\n"))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(`arg "a" "b"`, split.AST.Children[0].Dump()))
+	assert.Check(t, is.Equal(`arg "a=b"`, assigned.AST.Children[0].Dump()))
+}
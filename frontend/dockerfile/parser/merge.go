@@ -0,0 +1,132 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/moby/buildkit/frontend/dockerfile/command"
+)
+
+// MergeRuns combines every run of consecutive shell-form RUN instructions
+// into the first of them, joining their commands with " && " to save
+// layers, and returns the number of instructions merged away. RUN
+// instructions in JSON form or with here-documents are left alone, as are
+// ones whose flags differ, e.g. by a --mount, and ones following a command
+// that ends in an unquoted # comment, which would comment out what is
+// appended to it, or in a control operator like & or ;, which && can't
+// follow. Stages that set a SHELL other than the default ["/bin/sh", "-c"]
+// are left alone, as && isn't valid in every shell. Any other node in
+// between, including FROM and the comment and blank line nodes of a
+// lossless parse, keeps instructions apart. The AST is modified in place
+// and Original is rewritten for merged instructions.
+func MergeRuns(r *Result) int {
+	escapeToken := r.EscapeToken
+	if escapeToken == 0 {
+		escapeToken = DefaultEscapeToken
+	}
+	var children []*Node
+	merged := 0
+	customShell := false // whether the stage set a SHELL other than the default
+	for _, child := range r.AST.Children {
+		switch strings.ToLower(child.Value) {
+		case command.From:
+			customShell = false
+		case command.Shell:
+			if !isDefaultShell(child) {
+				customShell = true
+			}
+		}
+		if len(children) > 0 && !customShell {
+			if prev := children[len(children)-1]; canMergeRuns(prev, child, escapeToken) {
+				mergeRun(prev, child)
+				merged++
+				continue
+			}
+		}
+		children = append(children, child)
+	}
+	r.AST.Children = children
+	return merged
+}
+
+// canMergeRuns reports whether b can be appended to a, both being RUN
+// instructions in shell form with the same flags, and the command of a not
+// ending in a comment or a control operator
+func canMergeRuns(a, b *Node, escapeToken rune) bool {
+	for _, n := range []*Node{a, b} {
+		if !strings.EqualFold(n.Value, command.Run) || n.IsJSON() || len(n.Heredocs) > 0 || n.Next == nil {
+			return false
+		}
+	}
+	return equalFlags(a.Flags, b.Flags) && shellCommentIndex(a.Next.Value, escapeToken) < 0 && !endsInControlOperator(a.Next.Value, escapeToken)
+}
+
+// endsInControlOperator reports whether the last character of the command
+// is an unquoted and unescaped &, |, ; or (, e.g. in `sleep 1 &`, which
+// && can't follow
+func endsInControlOperator(cmd string, escapeToken rune) bool {
+	var quote rune
+	escaped := false
+	operator := false // whether the last character seen is an operator
+	for _, ch := range cmd {
+		if unicode.IsSpace(ch) && !escaped && quote == 0 {
+			continue
+		}
+		operator = false
+		switch {
+		case escaped:
+			escaped = false
+		case ch == escapeToken && quote != '\'':
+			escaped = true
+		case ch == '\'' && quote != '"', ch == '"' && quote != '\'':
+			if quote == ch {
+				quote = 0
+			} else {
+				quote = ch
+			}
+		case quote == 0 && strings.ContainsRune("&|;(", ch):
+			operator = true
+		}
+	}
+	return operator
+}
+
+// isDefaultShell reports whether the SHELL instruction sets the default
+// shell, ["/bin/sh", "-c"]
+func isDefaultShell(node *Node) bool {
+	args := node.ArgNodes()
+	return len(args) == 2 && args[0].Value == "/bin/sh" && args[1].Value == "-c"
+}
+
+// equalFlags reports whether a and b hold the same flags in any order
+func equalFlags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func mergeRun(a, b *Node) {
+	a.Next.Value += " && " + b.Next.Value
+	keyword := "RUN"
+	if fields := strings.Fields(a.Original); len(fields) > 0 {
+		keyword = fields[0]
+	}
+	// the offsets of the source lines don't apply to the new Original
+	a.Original = strings.Join(append([]string{keyword}, a.Flags...), " ") + " " + a.Next.Value
+	a.offsets = nil
+	a.Comments = append(a.Comments, b.Comments...)
+	a.RawLines = append(a.RawLines, b.RawLines...)
+	a.endLine = b.EndLine()
+	a.EndByte = b.EndByte
+}
@@ -0,0 +1,123 @@
+package parser
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestMergeRuns(t *testing.T) {
+	dockerfile := `FROM busybox
+RUN apt-get update
+RUN apt-get install -y git
+RUN --mount=type=cache,target=/cache make
+RUN --mount=type=cache,target=/cache make install
+RUN ["echo", "exec"]
+RUN echo shell
+COPY . /src
+RUN echo after copy
+FROM busybox
+RUN echo next stage
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+
+	assert.Check(t, is.Equal(2, MergeRuns(result)))
+	assert.Assert(t, is.Len(result.AST.Children, 9))
+
+	run := result.AST.Children[1]
+	assert.Check(t, is.Equal("apt-get update && apt-get install -y git", run.Next.Value))
+	assert.Check(t, is.Equal("RUN apt-get update && apt-get install -y git", run.Original))
+	assert.Check(t, is.Equal(2, run.StartLine))
+	assert.Check(t, is.Equal(3, run.EndLine()))
+
+	mount := result.AST.Children[2]
+	assert.Check(t, is.Equal("make && make install", mount.Next.Value))
+	assert.Check(t, is.DeepEqual([]string{"--mount=type=cache,target=/cache"}, mount.Flags))
+
+	buf := &bytes.Buffer{}
+	assert.NilError(t, result.Unparse(buf))
+	assert.Check(t, is.Equal(`FROM busybox
+RUN apt-get update && apt-get install -y git
+RUN --mount=type=cache,target=/cache make && make install
+RUN ["echo","exec"]
+RUN echo shell
+COPY . /src
+RUN echo after copy
+FROM busybox
+RUN echo next stage
+`, buf.String()))
+
+	assert.Check(t, is.Equal(0, MergeRuns(result)))
+}
+
+func TestMergeRunsDifferentFlags(t *testing.T) {
+	result, err := Parse(strings.NewReader("FROM busybox\nRUN --network=none make\nRUN make install\n"))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(0, MergeRuns(result)))
+	assert.Check(t, is.Len(result.AST.Children, 3))
+}
+
+func TestMergeRunsTrailingComment(t *testing.T) {
+	dockerfile := `FROM busybox
+RUN apt-get update # refresh
+RUN apt-get install -y curl
+RUN echo "# not a comment"
+RUN echo a\#b
+RUN echo done
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+
+	assert.Check(t, is.Equal(3, MergeRuns(result)))
+	assert.Assert(t, is.Len(result.AST.Children, 3))
+	assert.Check(t, is.Equal("apt-get update # refresh", result.AST.Children[1].Next.Value))
+	assert.Check(t, is.Equal(`apt-get install -y curl && echo "# not a comment" && echo a\#b && echo done`, result.AST.Children[2].Next.Value))
+}
+
+func TestMergeRunsControlOperator(t *testing.T) {
+	dockerfile := `FROM busybox
+RUN sleep 1 &
+RUN echo one
+RUN make;
+RUN echo two
+RUN find . -name '*.o' -exec rm {} \;
+RUN echo "a &"
+RUN echo three
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+
+	assert.Check(t, is.Equal(4, MergeRuns(result)))
+	var values []string
+	for _, child := range result.AST.Children[1:] {
+		values = append(values, child.Next.Value)
+	}
+	assert.Check(t, is.DeepEqual([]string{
+		"sleep 1 &",
+		"echo one && make;",
+		`echo two && find . -name '*.o' -exec rm {} \; && echo "a &" && echo three`,
+	}, values))
+}
+
+func TestMergeRunsCustomShell(t *testing.T) {
+	dockerfile := `FROM busybox
+SHELL ["powershell", "-command"]
+RUN Write-Host one
+RUN Write-Host two
+FROM busybox
+SHELL ["/bin/sh", "-c"]
+RUN echo one
+RUN echo two
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+
+	assert.Check(t, is.Equal(1, MergeRuns(result)))
+	assert.Assert(t, is.Len(result.AST.Children, 7))
+	assert.Check(t, is.Equal("Write-Host one", result.AST.Children[2].Next.Value))
+	assert.Check(t, is.Equal("echo one && echo two", result.AST.Children[6].Next.Value))
+}
@@ -0,0 +1,52 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// metaComment matches a trailing comment holding metadata, e.g.
+// `# cache-id: abc`
+var metaComment = regexp.MustCompile(`^#\s*([A-Za-z][A-Za-z0-9_.-]*):\s*(\S.*?)\s*$`)
+
+// splitTrailingMeta splits a trailing comment of the form `# key: value` off
+// the logical line. The line is returned as it is, with a nil map, if it has
+// no such comment.
+func splitTrailingMeta(line string, escapeToken rune) (string, map[string]string) {
+	i := shellCommentIndex(line, escapeToken)
+	if i < 0 {
+		return line, nil
+	}
+	m := metaComment.FindStringSubmatch(line[i:])
+	if m == nil {
+		return line, nil
+	}
+	return strings.TrimRightFunc(line[:i], unicode.IsSpace), map[string]string{m[1]: m[2]}
+}
+
+// shellCommentIndex returns the byte index of the comment the shell would
+// find in line, or -1 if there is none. The comment starts at the first #
+// that is preceded by whitespace and is neither quoted nor escaped.
+func shellCommentIndex(line string, escapeToken rune) int {
+	var quote, prev rune
+	escaped := false
+	for i, ch := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case ch == escapeToken && quote != '\'':
+			escaped = true
+		case ch == '\'' && quote != '"', ch == '"' && quote != '\'':
+			if quote == ch {
+				quote = 0
+			} else {
+				quote = ch
+			}
+		case ch == '#' && quote == 0 && i > 0 && unicode.IsSpace(prev):
+			return i
+		}
+		prev = ch
+	}
+	return -1
+}
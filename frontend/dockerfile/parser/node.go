@@ -0,0 +1,337 @@
+package parser
+
+import "strings"
+
+// Walk calls fn for the node, then for every node in its Next chain and then
+// recursively for the children of all of them. Walking stops at the first
+// error returned by fn, which is then returned by Walk.
+func (node *Node) Walk(fn func(*Node) error) error {
+	if err := fn(node); err != nil {
+		return err
+	}
+	for n := node.Next; n != nil; n = n.Next {
+		if err := fn(n); err != nil {
+			return err
+		}
+	}
+	for n := node; n != nil; n = n.Next {
+		for _, child := range n.Children {
+			if err := child.Walk(fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Transform calls fn for the node and, depth first, for every node
+// reachable from it, replacing each of them with the node fn returns. The
+// returned node keeps its own Next and Children, which are visited next, so
+// fn usually edits the node it is given and returns it. Returning nil
+// removes the node: from a Next chain, which is linked to the following
+// node instead, or from the children of its parent, together with its Next
+// chain. The tree is rewritten in place, Clone it first to keep the
+// original. Transform returns the replacement of the node itself, or nil.
+func (node *Node) Transform(fn func(*Node) *Node) *Node {
+	n := fn(node)
+	if n == nil {
+		return nil
+	}
+	n.transformChildren(fn)
+
+	prev := n
+	for next := n.Next; next != nil; {
+		r := fn(next)
+		if r == nil {
+			next = next.Next
+			prev.Next = next
+			continue
+		}
+		r.transformChildren(fn)
+		prev.Next = r
+		prev, next = r, r.Next
+	}
+	return n
+}
+
+func (node *Node) transformChildren(fn func(*Node) *Node) {
+	if len(node.Children) == 0 {
+		return
+	}
+	var children []*Node
+	for _, child := range node.Children {
+		if c := child.Transform(fn); c != nil {
+			children = append(children, c)
+		}
+	}
+	node.Children = children
+}
+
+// Depth returns how deeply the children of the node and of the nodes of its
+// Next chain are nested. A node without children has depth 0, the root of a
+// Dockerfile without ONBUILD instructions has depth 1 and every ONBUILD
+// trigger adds a level.
+func (node *Node) Depth() int {
+	depth := 0
+	for n := node; n != nil; n = n.Next {
+		for _, child := range n.Children {
+			if d := child.Depth() + 1; d > depth {
+				depth = d
+			}
+		}
+	}
+	return depth
+}
+
+// Size returns the number of nodes reachable from the node, itself
+// included, following both Next and Children.
+func (node *Node) Size() int {
+	size := 0
+	for n := node; n != nil; n = n.Next {
+		size++
+		for _, child := range n.Children {
+			size += child.Size()
+		}
+	}
+	return size
+}
+
+// WalkInstructions calls fn once for every top-level instruction, stopping at
+// the first error returned by fn.
+func (r *Result) WalkInstructions(fn func(*Node) error) error {
+	for _, child := range r.AST.Children {
+		if err := fn(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ArgNodes returns the nodes of the Next chain following the node, which
+// hold the arguments of an instruction, in order. Result.Args is unrelated,
+// it returns the build arguments declared by ARG instructions.
+func (node *Node) ArgNodes() []*Node {
+	var args []*Node
+	for n := node.Next; n != nil; n = n.Next {
+		args = append(args, n)
+	}
+	return args
+}
+
+// ArgDefault splits a word node of an ARG instruction, e.g. `VERSION=1.0`,
+// into the name of the build argument and its default value, with the quotes
+// and escape tokens removed like the builder does, e.g. `x y` for
+// `A="x y"`. References to variables are not expanded. hasDefault tells
+// `ARG VERSION=`, whose default is empty, from `ARG VERSION`, which has
+// none. The escape token is the one of the Dockerfile, Result.EscapeToken,
+// zero means \.
+//
+// The name and the default are not nodes of their own: every word of an ARG
+// instruction is a single node, keeping the AST and its Dump as they have
+// always been, and ArgDefault and SetArgDefault are the accessors of the two
+// parts.
+func (node *Node) ArgDefault(escapeToken rune) (name, value string, hasDefault bool) {
+	parts := strings.SplitN(node.Value, "=", 2)
+	if len(parts) == 1 {
+		return parts[0], "", false
+	}
+	return parts[0], unquoteWord(parts[1], escapeToken), true
+}
+
+// SetArgDefault sets the default value of the build argument of a word node
+// of an ARG instruction, keeping its name. The value is quoted if it isn't a
+// single word, so that ArgDefault returns it. Original is not changed.
+func (node *Node) SetArgDefault(value string, escapeToken rune) {
+	name, _, _ := node.ArgDefault(escapeToken)
+	d := NewDefaultDirective()
+	if escapeToken != 0 {
+		d.setEscapeToken(string(escapeToken))
+	}
+	node.Value = name + "=" + quoteWord(value, d)
+	if node.Attributes == nil {
+		node.Attributes = map[string]bool{}
+	}
+	node.Attributes["hasDefault"] = true
+}
+
+// unquoteWord removes the quotes from a word, and the escape tokens the
+// builder removes: outside of quotes those escaping any character, and in
+// double quotes those escaping ", $ or the escape token. Nothing is escaped
+// in single quotes.
+func unquoteWord(s string, escapeToken rune) string {
+	if escapeToken == 0 {
+		escapeToken = DefaultEscapeToken
+	}
+	var b strings.Builder
+	var quote rune
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		ch := runes[i]
+		switch {
+		case ch == escapeToken && quote != '\'':
+			if i+1 == len(runes) {
+				continue
+			}
+			if next := runes[i+1]; quote == 0 || next == '"' || next == '$' || next == escapeToken {
+				ch = next
+				i++
+			}
+		case ch == '\'' && quote != '"', ch == '"' && quote != '\'':
+			if quote == ch {
+				quote = 0
+			} else {
+				quote = ch
+			}
+			continue
+		}
+		b.WriteRune(ch)
+	}
+	return b.String()
+}
+
+// IsJSON reports whether the arguments of the instruction were given in JSON
+// (exec) form, e.g. `CMD ["echo", "hi"]`, rather than in shell form. This is
+// recorded as the "json" attribute by the instructions that accept both
+// forms: ADD, CMD, COPY, ENTRYPOINT, RUN, SHELL and VOLUME.
+func (node *Node) IsJSON() bool {
+	return node.Attributes["json"]
+}
+
+// InstructionAt returns the top-level instruction spanning the given line,
+// including its continuation lines and heredocs, or nil if there is none.
+// The blank line and comment nodes of a lossless parse are not instructions.
+func (r *Result) InstructionAt(line int) *Node {
+	for _, child := range r.AST.Children {
+		if child.StartLine > line {
+			break
+		}
+		if child.Value == BlankLineNode || child.Value == CommentNode {
+			continue
+		}
+		if line <= child.endLine {
+			return child
+		}
+	}
+	return nil
+}
+
+// FindAll returns every child of the node whose command matches cmd, compared
+// case-insensitively. Called on Result.AST it returns all top-level
+// instructions of that kind.
+func (node *Node) FindAll(cmd string) []*Node {
+	var nodes []*Node
+	for _, child := range node.Children {
+		if strings.EqualFold(child.Value, cmd) {
+			nodes = append(nodes, child)
+		}
+	}
+	return nodes
+}
+
+// Find returns the first child of the node whose command matches cmd,
+// compared case-insensitively, or nil if there is none.
+func (node *Node) Find(cmd string) *Node {
+	for _, child := range node.Children {
+		if strings.EqualFold(child.Value, cmd) {
+			return child
+		}
+	}
+	return nil
+}
+
+// Clone returns a deep copy of the node, including its Next chain and
+// children. Line information is preserved.
+func (node *Node) Clone() *Node {
+	if node == nil {
+		return nil
+	}
+	n := *node
+	n.Flags = cloneStrings(node.Flags)
+	n.Comments = cloneStrings(node.Comments)
+	n.RawLines = cloneStrings(node.RawLines)
+	if node.Heredocs != nil {
+		n.Heredocs = append([]Heredoc{}, node.Heredocs...)
+	}
+	if node.offsets != nil {
+		n.offsets = append([]lineOffset{}, node.offsets...)
+	}
+	if node.Attributes != nil {
+		n.Attributes = make(map[string]bool, len(node.Attributes))
+		for k, v := range node.Attributes {
+			n.Attributes[k] = v
+		}
+	}
+	if node.Meta != nil {
+		n.Meta = make(map[string]string, len(node.Meta))
+		for k, v := range node.Meta {
+			n.Meta[k] = v
+		}
+	}
+	if node.Children != nil {
+		n.Children = make([]*Node, len(node.Children))
+		for i, child := range node.Children {
+			n.Children[i] = child.Clone()
+		}
+	}
+	n.Next = node.Next.Clone()
+	return &n
+}
+
+// Equal reports whether the node has the same structure as other: the same
+// Value, Flags, Attributes, Heredocs, Children and Next chain. Line
+// information, Original, comments, raw lines and Meta are ignored, and nil and
+// empty collections are considered equal.
+func (node *Node) Equal(other *Node) bool {
+	if node == nil || other == nil {
+		return node == other
+	}
+	if node.Value != other.Value ||
+		!equalStrings(node.Flags, other.Flags) ||
+		!equalAttributes(node.Attributes, other.Attributes) ||
+		len(node.Heredocs) != len(other.Heredocs) ||
+		len(node.Children) != len(other.Children) {
+		return false
+	}
+	for i := range node.Heredocs {
+		if node.Heredocs[i] != other.Heredocs[i] {
+			return false
+		}
+	}
+	for i := range node.Children {
+		if !node.Children[i].Equal(other.Children[i]) {
+			return false
+		}
+	}
+	return node.Next.Equal(other.Next)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalAttributes(a, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
+
+func cloneStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string{}, s...)
+}
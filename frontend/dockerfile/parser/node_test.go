@@ -0,0 +1,292 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+const multiStageDockerfile = `FROM golang AS build
+RUN go build -o /app .
+FROM alpine
+COPY --from=build /app /app
+ONBUILD RUN echo hello world
+CMD ["/app"]
+`
+
+func TestWalk(t *testing.T) {
+	result, err := Parse(strings.NewReader(multiStageDockerfile))
+	assert.NilError(t, err)
+
+	var values []string
+	err = result.AST.Walk(func(n *Node) error {
+		values = append(values, n.Value)
+		return nil
+	})
+	assert.NilError(t, err)
+	// root, 6 instructions, their 9 arguments, the ONBUILD wrapper and
+	// trigger instruction
+	assert.Check(t, is.Len(values, 18))
+	assert.Check(t, is.DeepEqual([]string{"", "from", "golang", "AS", "build"}, values[:5]))
+	assert.Check(t, is.DeepEqual([]string{"onbuild", "", "run", "echo hello world"}, values[12:16]))
+
+	errStop := errors.New("stop")
+	var count int
+	err = result.AST.Walk(func(n *Node) error {
+		count++
+		if n.Value == "alpine" {
+			return errStop
+		}
+		return nil
+	})
+	assert.Check(t, is.Equal(errStop, err))
+	assert.Check(t, is.Equal(9, count))
+}
+
+func TestTransform(t *testing.T) {
+	result, err := Parse(strings.NewReader(`FROM ubuntu:latest AS build
+RUN make
+FROM ubuntu:latest
+ONBUILD RUN echo hi
+`))
+	assert.NilError(t, err)
+
+	pinned := "ubuntu@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	ast := result.AST.Transform(func(n *Node) *Node {
+		if n.Value == "from" && n.Next != nil && n.Next.Value == "ubuntu:latest" {
+			n.Next = &Node{Value: pinned, Next: n.Next.Next}
+		}
+		return n
+	})
+	assert.Check(t, ast == result.AST)
+	assert.Check(t, is.DeepEqual([]string{"from", pinned, "AS", "build"}, nodeValues(ast.Children[0])))
+	assert.Check(t, is.DeepEqual([]string{"from", pinned}, nodeValues(ast.Children[2])))
+	assert.Check(t, is.Equal("run", ast.Children[3].Next.Children[0].Value))
+}
+
+func TestTransformRemove(t *testing.T) {
+	result, err := Parse(strings.NewReader(`FROM busybox
+MAINTAINER me
+RUN make
+MAINTAINER someone else
+ARG a b c
+`))
+	assert.NilError(t, err)
+
+	ast := result.AST.Transform(func(n *Node) *Node {
+		if n.Value == "maintainer" || n.Value == "b" {
+			return nil
+		}
+		return n
+	})
+	var commands []string
+	for _, child := range ast.Children {
+		commands = append(commands, child.Value)
+	}
+	assert.Check(t, is.DeepEqual([]string{"from", "run", "arg"}, commands))
+	// arguments removed from a Next chain are unlinked
+	assert.Check(t, is.DeepEqual([]string{"arg", "a", "c"}, nodeValues(ast.Children[2])))
+
+	assert.Check(t, ast.Transform(func(*Node) *Node { return nil }) == nil)
+}
+
+func TestDepthAndSize(t *testing.T) {
+	result, err := Parse(strings.NewReader("FROM busybox\nRUN echo hi\n"))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(1, result.AST.Depth()))
+	// the root, two instructions and their arguments
+	assert.Check(t, is.Equal(5, result.AST.Size()))
+	assert.Check(t, is.Equal(0, result.AST.Children[1].Depth()))
+	assert.Check(t, is.Equal(2, result.AST.Children[1].Size()))
+
+	result, err = Parse(strings.NewReader("FROM busybox\nONBUILD RUN make\n"))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(2, result.AST.Depth()))
+	assert.Check(t, is.Equal(7, result.AST.Size()))
+	// ONBUILD, the node holding the trigger, RUN and its argument
+	assert.Check(t, is.Equal(1, result.AST.Children[1].Depth()))
+	assert.Check(t, is.Equal(4, result.AST.Children[1].Size()))
+
+	result, err = Parse(strings.NewReader("FROM busybox\nONBUILD ONBUILD RUN make\n"))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(3, result.AST.Depth()))
+	assert.Check(t, is.Equal(9, result.AST.Size()))
+}
+
+func TestWalkInstructions(t *testing.T) {
+	result, err := Parse(strings.NewReader(multiStageDockerfile))
+	assert.NilError(t, err)
+
+	var commands []string
+	err = result.WalkInstructions(func(n *Node) error {
+		commands = append(commands, n.Value)
+		return nil
+	})
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{"from", "run", "from", "copy", "onbuild", "cmd"}, commands))
+}
+
+func TestArgNodes(t *testing.T) {
+	result, err := Parse(strings.NewReader("FROM busybox\nCOPY --chown=app a b c\nRUN\n"))
+	assert.NilError(t, err)
+
+	var values []string
+	for _, arg := range result.AST.Children[1].ArgNodes() {
+		values = append(values, arg.Value)
+	}
+	assert.Check(t, is.DeepEqual([]string{"a", "b", "c"}, values))
+	assert.Check(t, is.Len(result.AST.Children[2].ArgNodes(), 0))
+}
+
+func TestIsJSON(t *testing.T) {
+	result, err := Parse(strings.NewReader(`FROM busybox
+CMD ["echo", "hi"]
+CMD echo hi
+CMD [ "echo",
+  "hi" ]
+CMD [echo, hi]
+ENTRYPOINT ["/bin/sh"]
+ENTRYPOINT /bin/sh
+RUN ["true"]
+RUN true
+`))
+	assert.NilError(t, err)
+
+	var forms []bool
+	for _, child := range result.AST.Children {
+		forms = append(forms, child.IsJSON())
+	}
+	assert.Check(t, is.DeepEqual([]bool{false, true, false, true, false, true, false, true, false}, forms))
+}
+
+func TestInstructionAt(t *testing.T) {
+	dockerfile := `FROM busybox
+
+RUN apt-get update && \
+    apt-get install -y curl && \
+    rm -rf /var/lib/apt/lists
+# comment
+CMD ["curl"]
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+
+	run := result.AST.Children[1]
+	for _, line := range []int{3, 4, 5} {
+		assert.Check(t, is.Equal(run, result.InstructionAt(line)), "line %d", line)
+	}
+	assert.Check(t, is.Equal(result.AST.Children[0], result.InstructionAt(1)))
+	assert.Check(t, is.Equal(result.AST.Children[2], result.InstructionAt(7)))
+	for _, line := range []int{0, 2, 6, 8} {
+		assert.Check(t, result.InstructionAt(line) == nil, "line %d", line)
+	}
+
+	lossless, err := ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{Lossless: true})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("run", lossless.InstructionAt(4).Value))
+	for _, line := range []int{2, 6} {
+		assert.Check(t, lossless.InstructionAt(line) == nil, "line %d", line)
+	}
+}
+
+func TestFind(t *testing.T) {
+	result, err := Parse(strings.NewReader(`FROM busybox
+RUN echo one
+run echo two
+CMD ["true"]
+`))
+	assert.NilError(t, err)
+
+	runs := result.AST.FindAll("RUN")
+	assert.Assert(t, is.Len(runs, 2))
+	assert.Check(t, is.Equal("echo one", runs[0].Next.Value))
+	assert.Check(t, is.Equal("echo two", runs[1].Next.Value))
+	assert.Check(t, is.Len(result.AST.FindAll("from"), 1))
+	assert.Check(t, is.Len(result.AST.FindAll("copy"), 0))
+
+	assert.Check(t, is.Equal(runs[0], result.AST.Find("Run")))
+	assert.Check(t, is.Equal(result.AST.Children[0], result.AST.Find("FROM")))
+	assert.Check(t, result.AST.Find("HEALTHCHECK") == nil)
+}
+
+func TestClone(t *testing.T) {
+	result, err := Parse(strings.NewReader(multiStageDockerfile))
+	assert.NilError(t, err)
+	original := result.AST.Dump()
+
+	clone := result.AST.Clone()
+	assert.Check(t, is.Equal(original, clone.Dump()))
+	assert.Check(t, is.DeepEqual(result.AST, clone, cmpNodeOpt))
+
+	cmd := clone.Children[5]
+	cmd.Value = "entrypoint"
+	cmd.Attributes["json"] = false
+	clone.Children[3].Flags[0] = "--from=other"
+	clone.Children[4].Next.Children[0].Next.Value = "echo changed"
+	clone.Children[0].Next.Next.Next = nil
+
+	assert.Check(t, is.Equal(original, result.AST.Dump()))
+	assert.Check(t, is.DeepEqual(map[string]bool{"json": true}, result.AST.Children[5].Attributes))
+	assert.Check(t, is.Equal(result.AST.Children[1].StartLine, clone.Children[1].StartLine))
+	assert.Check(t, is.Equal(result.AST.Children[1].EndLine(), clone.Children[1].EndLine()))
+}
+
+func TestEqual(t *testing.T) {
+	a, err := Parse(strings.NewReader(`FROM busybox AS base
+RUN echo hello
+CMD ["sh", "-c", "true"]
+ONBUILD COPY --from=base a b
+`))
+	assert.NilError(t, err)
+
+	b, err := Parse(strings.NewReader(`# with comments and a different layout
+
+from busybox AS base
+# say hello
+RUN echo \
+hello
+CMD ["sh",
+  "-c", "true"]
+
+onbuild copy --from=base a b
+`))
+	assert.NilError(t, err)
+
+	assert.Check(t, a.AST.Equal(b.AST))
+	assert.Check(t, a.AST.Children[1].StartLine != b.AST.Children[1].StartLine)
+
+	c := a.AST.Clone()
+	c.Children[3].Next.Children[0].Flags[0] = "--from=other"
+	assert.Check(t, !a.AST.Equal(c))
+
+	c = a.AST.Clone()
+	c.Children[2].Next.Next.Next = nil
+	assert.Check(t, !a.AST.Equal(c))
+
+	c = a.AST.Clone()
+	c.Children[1].Attributes = map[string]bool{}
+	assert.Check(t, a.AST.Equal(c))
+	c.Children[1].Attributes["json"] = true
+	assert.Check(t, !a.AST.Equal(c))
+
+	var nilNode *Node
+	assert.Check(t, nilNode.Equal(nil))
+	assert.Check(t, !a.AST.Equal(nil))
+}
+
+func TestDumpIsDeterministic(t *testing.T) {
+	result, err := Parse(strings.NewReader("FROM busybox\nARG a=1 b c=3\nCMD [\"echo\", \"hi\"]\n"))
+	assert.NilError(t, err)
+	cmd := result.AST.Children[2]
+	cmd.Attributes["hasDefault"] = true
+	assert.Check(t, is.Len(cmd.Attributes, 2))
+
+	dump, indented := result.AST.Dump(), result.AST.DumpIndent()
+	for i := 0; i < 20; i++ {
+		assert.Check(t, is.Equal(dump, result.AST.Dump()))
+		assert.Check(t, is.Equal(indented, result.AST.DumpIndent()))
+	}
+}
@@ -4,12 +4,13 @@ package parser
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
-	"unicode"
 
 	"github.com/moby/buildkit/frontend/dockerfile/command"
 	"github.com/pkg/errors"
@@ -28,18 +29,48 @@ import (
 // works a little more effectively than a "proper" parse tree for our needs.
 //
 type Node struct {
-	Value      string          // actual content
-	Next       *Node           // the next item in the current sexp
-	Children   []*Node         // the children of this sexp
-	Attributes map[string]bool // special attributes for this node
-	Original   string          // original line used before parsing
-	Flags      []string        // only top Node should have this set
-	StartLine  int             // the line in the original dockerfile where the node begins
-	endLine    int             // the line in the original dockerfile where the node ends
+	Value      string            // actual content
+	Next       *Node             // the next item in the current sexp
+	Children   []*Node           // the children of this sexp
+	Attributes map[string]bool   // special attributes for this node
+	Original   string            // original line used before parsing
+	Flags      []string          // only top Node should have this set
+	Comments   []string          // comment lines preceding the instruction, if preserved
+	Heredocs   []Heredoc         // here-documents following the instruction
+	RawLines   []string          // physical source lines of the instruction, if preserved
+	StartLine  int               // the line in the original dockerfile where the node begins
+	endLine    int               // the line in the original dockerfile where the node ends
+	StartByte  int               // the byte offset in the original dockerfile where the node begins
+	EndByte    int               // the byte offset in the original dockerfile where the node ends (exclusive)
+	offsets    []lineOffset      // maps positions in Original back to the original dockerfile
+	Meta       map[string]string // metadata of a trailing `# key: value` comment, if captured
+}
+
+// lineOffset records where a physical line that is part of a node's
+// Original starts, both in Original and in the original dockerfile.
+type lineOffset struct {
+	pos    int // position in Original
+	offset int // byte offset in the original dockerfile
+}
+
+// SourceOffset maps a byte position in Original to the byte offset in the
+// original dockerfile. Positions on continuation lines map to the physical
+// line they were read from.
+func (node *Node) SourceOffset(pos int) int {
+	offset := node.StartByte + pos
+	for _, o := range node.offsets {
+		if o.pos > pos {
+			break
+		}
+		offset = o.offset + pos - o.pos
+	}
+	return offset
 }
 
 // Dump dumps the AST defined by `node` as a list of sexps.
-// Returns a string suitable for printing.
+// Returns a string suitable for printing. The output only depends on the
+// ordered parts of the AST, Attributes are not dumped, so it is stable
+// across runs.
 func (node *Node) Dump() string {
 	str := ""
 	str += node.Value
@@ -63,9 +94,113 @@ func (node *Node) Dump() string {
 	return strings.TrimSpace(str)
 }
 
+// DumpIndent dumps the AST with one instruction per line, annotated with its
+// line range and flags, e.g. `RUN [2-3] ["--network=none"]: "make"`. The
+// instructions nested in an ONBUILD are indented below it.
+func (node *Node) DumpIndent() string {
+	var sb strings.Builder
+	node.dumpIndent(&sb, "")
+	return sb.String()
+}
+
+func (node *Node) dumpIndent(sb *strings.Builder, indent string) {
+	if node.Value == "" {
+		// the root node
+		for _, child := range node.Children {
+			child.dumpIndent(sb, indent)
+		}
+		return
+	}
+
+	fmt.Fprintf(sb, "%s%s [%d-%d]", indent, strings.ToUpper(node.Value), node.StartLine, node.endLine)
+	if len(node.Flags) > 0 {
+		fmt.Fprintf(sb, " %q", node.Flags)
+	}
+	sb.WriteString(":")
+	for n := node.Next; n != nil; n = n.Next {
+		if len(n.Children) == 0 {
+			sb.WriteString(" " + strconv.Quote(n.Value))
+		}
+	}
+	sb.WriteString("\n")
+	for n := node.Next; n != nil; n = n.Next {
+		for _, child := range n.Children {
+			child.dumpIndent(sb, indent+"  ")
+		}
+	}
+}
+
+// EndLine returns the line in the original dockerfile where the node ends
+func (node *Node) EndLine() int {
+	return node.endLine
+}
+
+// lines sets the line information of the node and of any instructions nested
+// in it, e.g. the trigger of an ONBUILD
 func (node *Node) lines(start, end int) {
 	node.StartLine = start
 	node.endLine = end
+	for n := node.Next; n != nil; n = n.Next {
+		for _, child := range n.Children {
+			child.lines(start, end)
+		}
+	}
+}
+
+// normalize uppercases the keyword of the instruction and of any
+// instructions nested in it
+func (node *Node) normalize() {
+	node.Value = strings.ToUpper(node.Value)
+	for n := node.Next; n != nil; n = n.Next {
+		for _, child := range n.Children {
+			child.normalize()
+		}
+	}
+}
+
+// canonicalizeWhitespace collapses the runs of whitespace outside quotes in
+// the shell-form arguments of the node and its nested instructions to single
+// spaces. The elements of JSON arrays are left as they are.
+func (node *Node) canonicalizeWhitespace(escapeToken rune) {
+	for n := node.Next; n != nil; n = n.Next {
+		for _, child := range n.Children {
+			child.canonicalizeWhitespace(escapeToken)
+		}
+		if !node.Attributes["json"] && len(n.Children) == 0 {
+			n.Value = collapseWhitespace(n.Value, escapeToken)
+		}
+	}
+}
+
+// collapseWhitespace replaces every run of unquoted, unescaped whitespace in
+// s with a single space and trims the whitespace around s
+func collapseWhitespace(s string, escapeToken rune) string {
+	var b strings.Builder
+	var quote rune
+	space, escaped := false, false
+	for _, ch := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case ch == escapeToken && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case isWhitespace(ch):
+			space = true
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteRune(ch)
+	}
+	return b.String()
 }
 
 // AddChild adds a new child node, and updates line information
@@ -79,36 +214,111 @@ func (node *Node) AddChild(child *Node, startLine, endLine int) {
 }
 
 var (
-	dispatch                 map[string]func(string, *Directive) (*Node, map[string]bool, error)
-	tokenWhitespace          = regexp.MustCompile(`[\t\v\f\r ]+`)
-	tokenEscapeCommand       = regexp.MustCompile(`^#[ \t]*escape[ \t]*=[ \t]*(?P<escapechar>.).*$`)
-	tokenComment             = regexp.MustCompile(`^#.*$`)
-	lineJSONArrayContinuator = regexp.MustCompile(`[^"]*\[[^\]]*$`)
+	dispatch             map[string]func(string, *Directive) (*Node, map[string]bool, error)
+	tokenWhitespace      = regexp.MustCompile(`[` + whitespace + `]+`)
+	tokenParserDirective = regexp.MustCompile(`^#[ \t]*([a-zA-Z][a-zA-Z0-9]*)[ \t]*=[ \t]*(\S.*)$`)
+	tokenComment         = regexp.MustCompile(`^#.*$`)
 )
 
+// whitespace holds the characters that separate words and are trimmed from
+// lines. Other Unicode spaces, like the non-breaking space U+00A0, are part
+// of the words they appear in.
+const whitespace = " \t\v\f\r"
+
+// isWhitespace reports whether ch is in whitespace
+func isWhitespace(ch rune) bool {
+	return strings.ContainsRune(whitespace, ch)
+}
+
 // DefaultEscapeToken is the default escape token
 const DefaultEscapeToken = '\\'
 
+const (
+	directiveEscape = "escape"
+	directiveSyntax = "syntax"
+	directiveCheck  = "check"
+)
+
+// parserDirectives is the set of parser directives that are recognized.
+// Names are matched case-insensitively.
+var parserDirectives = map[string]struct{}{
+	directiveEscape: {},
+	directiveSyntax: {},
+	directiveCheck:  {},
+}
+
 // Directive is the structure used during a build run to hold the state of
-// parsing directives.
+// parsing directives. The escape token can only change while the parser
+// directives at the top of the Dockerfile are read. Once the first line that
+// isn't a directive was seen, processing is complete and the escape token is
+// fixed, as the lines read so far depend on it.
+//
+// With the default escape token a backslash that ends a line continues the
+// instruction, even if it is meant as a path separator like in
+// `WORKDIR C:\app\`. Dockerfiles using Windows paths should set the escape
+// token to a backtick with the directive "# escape=" followed by a backtick.
 type Directive struct {
-	escapeToken        rune           // Current escape token
-	lineEscapeRegex    *regexp.Regexp // Current line escape regex
-	processingComplete bool           // Whether we are done looking for directives
-	escapeSeen         bool           // Whether the escape directive has been seen
+	escapeToken        rune                         // Current escape token
+	lineEscapeRegex    *regexp.Regexp               // Current line escape regex
+	processingComplete bool                         // Whether we are done looking for directives
+	directives         map[string]string            // Values of the parser directives that have been seen
+	collectUnknown     bool                         // Whether unknown directives are collected too
+	commands           map[string]InstructionParser // Parsers of custom instructions, by lowercased name
+	initial            map[string]bool              // Directives set before parsing, which the Dockerfile can override
 }
 
 // setEscapeToken sets the default token for escaping characters in a Dockerfile.
+// It fails once directive processing is complete.
 func (d *Directive) setEscapeToken(s string) error {
 	if s != "`" && s != "\\" {
 		return fmt.Errorf("invalid ESCAPE '%s'. Must be ` or \\", s)
 	}
+	if d.processingComplete {
+		return errors.New("the escape token can't be changed after the parser directives")
+	}
 	d.escapeToken = rune(s[0])
-	d.lineEscapeRegex = regexp.MustCompile(`\` + s + `[ \t]*$`)
+	d.lineEscapeRegex = regexp.MustCompile(`\` + s + `[` + whitespace + `]*$`)
 	return nil
 }
 
-// possibleParserDirective looks for parser directives, eg '# escapeToken=<char>'.
+// EscapeToken returns the current escape token
+func (d *Directive) EscapeToken() rune {
+	return d.escapeToken
+}
+
+// IsLineContinued reports whether line ends with the current escape token,
+// optionally followed by spaces or tabs, and so continues on the next line.
+func (d *Directive) IsLineContinued(line string) bool {
+	return d.lineEscapeRegex.MatchString(line)
+}
+
+// Directives returns the parser directives that have been seen, keyed by
+// their lowercased name.
+func (d *Directive) Directives() map[string]string {
+	directives := make(map[string]string, len(d.directives))
+	for k, v := range d.directives {
+		directives[k] = v
+	}
+	return directives
+}
+
+// misplacedDirective returns the name of the known parser directive the
+// comment line looks like, if any. It is used to flag directives that are
+// ignored because they follow an instruction.
+func misplacedDirective(line []byte) string {
+	match := tokenParserDirective.FindSubmatch(trimWhitespace(line))
+	if len(match) == 0 {
+		return ""
+	}
+	name := strings.ToLower(string(match[1]))
+	if _, ok := parserDirectives[name]; !ok {
+		return ""
+	}
+	return name
+}
+
+// possibleParserDirective looks for parser directives, eg '# escapeToken=<char>'
+// or '# syntax=<image>'.
 // Parser directives must precede any builder instruction or other comments,
 // and cannot be repeated.
 func (d *Directive) possibleParserDirective(line string) error {
@@ -116,20 +326,82 @@ func (d *Directive) possibleParserDirective(line string) error {
 		return nil
 	}
 
-	tecMatch := tokenEscapeCommand.FindStringSubmatch(strings.ToLower(line))
-	if len(tecMatch) != 0 {
-		for i, n := range tokenEscapeCommand.SubexpNames() {
-			if n == "escapechar" {
-				if d.escapeSeen {
-					return errors.New("only one escape parser directive can be used")
-				}
-				d.escapeSeen = true
-				return d.setEscapeToken(tecMatch[i])
+	match := tokenParserDirective.FindStringSubmatch(line)
+	if len(match) == 0 {
+		d.processingComplete = true
+		return nil
+	}
+	name := strings.ToLower(match[1])
+	if _, ok := parserDirectives[name]; !ok && !d.collectUnknown {
+		d.processingComplete = true
+		return nil
+	}
+	if _, ok := d.directives[name]; ok && !d.initial[name] {
+		return errors.Errorf("only one %s parser directive can be used", name)
+	}
+	delete(d.initial, name)
+	value := strings.TrimSpace(match[2])
+	if d.directives == nil {
+		d.directives = map[string]string{}
+	}
+	d.directives[name] = value
+
+	if name == directiveCheck {
+		return validateCheckDirective(value)
+	}
+	if name == directiveEscape {
+		// only the first character is significant, as it has always been
+		if value != "" {
+			value = value[:1]
+		}
+		return d.setEscapeToken(value)
+	}
+	return nil
+}
+
+// setInitialDirectives sets the parser directives before the Dockerfile is
+// read, validating their values like possibleParserDirective does. Unknown
+// directives are only accepted if they are collected.
+func (d *Directive) setInitialDirectives(directives map[string]string) error {
+	for name, value := range directives {
+		name = strings.ToLower(name)
+		if _, ok := parserDirectives[name]; !ok && !d.collectUnknown {
+			return errors.Errorf("unknown parser directive %q", name)
+		}
+		value = strings.TrimSpace(value)
+		switch name {
+		case directiveCheck:
+			if err := validateCheckDirective(value); err != nil {
+				return err
+			}
+		case directiveEscape:
+			if err := d.setEscapeToken(value); err != nil {
+				return err
 			}
 		}
+		if d.directives == nil {
+			d.directives = map[string]string{}
+		}
+		if d.initial == nil {
+			d.initial = map[string]bool{}
+		}
+		d.directives[name] = value
+		d.initial[name] = true
 	}
+	return nil
+}
 
-	d.processingComplete = true
+// validateCheckDirective makes sure the value of a check parser directive is
+// a list of key=value pairs separated by semicolons, e.g.
+// `skip=StageNameCasing;error=true`. The meaning of the keys is left to the
+// frontend.
+func validateCheckDirective(value string) error {
+	for _, part := range strings.Split(value, ";") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return errors.Errorf("invalid check parser directive %q: must be a list of key=value pairs separated by ;", value)
+		}
+	}
 	return nil
 }
 
@@ -169,6 +441,89 @@ func init() {
 	}
 }
 
+// InstructionParser parses the arguments of an instruction, i.e. the line
+// following the command and its flags, into the Next chain of the
+// instruction node and its attributes.
+type InstructionParser func(args string, d *Directive) (*Node, map[string]bool, error)
+
+// lookupCommand returns the parser of an instruction, or nil if the
+// instruction is unknown
+func (d *Directive) lookupCommand(cmd string) InstructionParser {
+	if fn, ok := dispatch[cmd]; ok {
+		return fn
+	}
+	return d.commands[cmd]
+}
+
+// ReparseLine parses a single instruction with the directive state d, e.g.
+// to update the AST of a file being edited without parsing all of it again.
+// The instruction may span several lines joined by the escape token of d,
+// comment lines and empty lines among them are skipped, and the lines
+// following the end of the instruction are ignored. Line information is
+// not set and the arguments of unknown instructions are dropped, as they
+// are by Parse. Use Result.Directive to get the directive state of a parsed
+// file.
+func ReparseLine(line string, d *Directive) (*Node, error) {
+	var logical string
+	for i, physical := range strings.Split(line, "\n") {
+		physical = strings.TrimRight(physical, "\r")
+		if i == 0 {
+			physical = strings.TrimLeft(physical, whitespace)
+		} else if isComment([]byte(physical)) || isEmptyContinuationLine([]byte(physical)) {
+			continue
+		}
+		logical += physical
+		var isEndOfLine bool
+		if logical, isEndOfLine = continuateLine(logical, d); isEndOfLine {
+			break
+		}
+	}
+	if strings.TrimLeft(logical, whitespace) == "" {
+		return nil, errors.New("no instruction to parse")
+	}
+	return newNodeFromLine(logical, d)
+}
+
+// Directive returns the directive state the file was parsed with, for use
+// with ReparseLine.
+func (r *Result) Directive() *Directive {
+	d := NewDefaultDirective()
+	if r.EscapeToken != 0 {
+		d.setEscapeToken(string(r.EscapeToken))
+	}
+	d.processingComplete = true
+	return d
+}
+
+// StripDirectives reads the parser directives at the top of a Dockerfile, e.g.
+// to hand the rest of the file to a builder that doesn't know them. It
+// returns the directives keyed by their lowercased name, and a reader of the
+// file starting at the first line that isn't a parser directive.
+func StripDirectives(r io.Reader) (map[string]string, io.Reader, error) {
+	br := bufio.NewReader(r)
+	d := NewDefaultDirective()
+	for line := 1; ; line++ {
+		content, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		text := bytes.TrimRight(content, "\r\n")
+		if line == 1 {
+			text = bytes.TrimPrefix(text, utf8bom)
+		}
+		n := len(d.directives)
+		if perr := d.possibleParserDirective(string(trimWhitespace(text))); perr != nil {
+			return nil, nil, newParseError(line, perr)
+		}
+		if len(d.directives) == n {
+			return d.Directives(), io.MultiReader(bytes.NewReader(content), br), nil
+		}
+		if err == io.EOF {
+			return d.Directives(), br, nil
+		}
+	}
+}
+
 // newNodeFromLine splits the line into parts, and dispatches to a function
 // based on the command and command arguments. A Node is created from the
 // result of the dispatch.
@@ -178,7 +533,7 @@ func newNodeFromLine(line string, directive *Directive) (*Node, error) {
 		return nil, err
 	}
 
-	fn := dispatch[cmd]
+	fn := directive.lookupCommand(cmd)
 	// Ignore invalid Dockerfile instructions
 	if fn == nil {
 		fn = parseIgnore
@@ -201,7 +556,36 @@ func newNodeFromLine(line string, directive *Directive) (*Node, error) {
 type Result struct {
 	AST         *Node
 	EscapeToken rune
-	Warnings    []string
+	Syntax      string
+	// Check is the raw value of the check parser directive, if any
+	Check string
+	// Directives holds the values of all parser directives, keyed by their
+	// lowercased name
+	Directives map[string]string
+	// Warnings holds the warnings found while parsing, formatted for
+	// printing
+	Warnings []string
+	// StructuredWarnings holds the same warnings as Warnings, except for
+	// the notice that empty continuation lines are deprecated
+	StructuredWarnings []Warning
+	// TrailingComments holds the comment lines after the last instruction
+	// when comments are preserved
+	TrailingComments []string
+	// LineCount is the number of physical lines read, including comments,
+	// blank lines, continuation lines and heredocs
+	LineCount int
+}
+
+// InstructionCount returns the number of top-level instructions, not
+// counting the nodes of blank and comment lines added in lossless mode.
+func (r *Result) InstructionCount() int {
+	count := 0
+	for _, child := range r.AST.Children {
+		if child.Value != BlankLineNode && child.Value != CommentNode {
+			count++
+		}
+	}
+	return count
 }
 
 // PrintWarnings to the writer
@@ -209,17 +593,265 @@ func (r *Result) PrintWarnings(out io.Writer) {
 	if len(r.Warnings) == 0 {
 		return
 	}
-	fmt.Fprintf(out, strings.Join(r.Warnings, "\n")+"\n")
+	fmt.Fprint(out, strings.Join(r.Warnings, "\n")+"\n")
+}
+
+// ParseOptions controls the behavior of ParseWithOptions. The zero value
+// gives the same behavior as Parse.
+type ParseOptions struct {
+	// MaxLineSize is the maximum size of a single line in bytes. Defaults to
+	// bufio.MaxScanTokenSize-1.
+	MaxLineSize int
+	// EmptyContinuationLineError turns empty continuation lines into errors
+	// instead of warnings. The error is reported at the first empty line.
+	EmptyContinuationLineError bool
+	// PreserveComments attaches the comment lines preceding an instruction
+	// to its node instead of discarding them. Parser directives are not
+	// considered comments.
+	PreserveComments bool
+	// CollectErrors makes the parser continue past instructions that fail
+	// to parse. All errors are returned as ParseErrors together with a
+	// Result holding the instructions that did parse.
+	CollectErrors bool
+	// Strict reports the problems found while validating instructions, e.g.
+	// unknown instructions or malformed EXPOSE ports, as errors instead of
+	// warnings. Problems of the rules skipped by the check parser directive
+	// are neither.
+	Strict bool
+	// Lossless adds a node for every blank line and every comment line
+	// between instructions to the AST, in source order. Their Value is
+	// BlankLineNode or CommentNode and Original holds the comment. Parser
+	// directives and comments inside continuation lines don't get nodes.
+	Lossless bool
+	// RawLines keeps the physical source lines each instruction was read
+	// from, including comment and empty lines inside continuations, in
+	// Node.RawLines. The bodies of here-documents are not included.
+	RawLines bool
+	// Normalize stores the keywords of instructions uppercased in
+	// Node.Value, e.g. "FROM" instead of "from", and warns about keywords
+	// that mix upper and lower case. Node.Original keeps the keyword as
+	// written.
+	Normalize bool
+	// CollectDirectives keeps every comment of the form `# name=value` in
+	// the parser directive position in Result.Directives, not only the
+	// directives known to the parser. Unknown directives have no effect on
+	// parsing.
+	CollectDirectives bool
+	// Commands adds parsers for custom instructions, keyed by the
+	// case-insensitive instruction name, e.g. for an INCLUDE handled by a
+	// preprocessor. Built-in instructions can't be overridden.
+	Commands map[string]InstructionParser
+	// AllowMaintainer suppresses the deprecation warning emitted for every
+	// MAINTAINER instruction.
+	AllowMaintainer bool
+	// MaxLayerInstructions limits the number of RUN, COPY and ADD
+	// instructions, which produce layers, in a single stage. A stage with
+	// more is reported with a warning, or an error in strict mode. Zero
+	// means no limit.
+	MaxLayerInstructions int
+	// DefaultEscapeToken is the escape token used unless the Dockerfile has
+	// an escape parser directive, e.g. when it is given as a frontend
+	// option. It must be \ or `. Zero means \.
+	DefaultEscapeToken rune
+	// CanonicalWhitespace collapses the runs of whitespace in shell-form
+	// arguments to single spaces and trims the whitespace around them, e.g.
+	// for hashing Dockerfiles that only differ in formatting. Whitespace
+	// inside quotes or escaped with the escape token is kept, as are the
+	// elements of JSON arrays and here-documents. Node.Original is not
+	// changed.
+	CanonicalWhitespace bool
+	// OnWarning is called with every warning as soon as it is found,
+	// instead of collecting the warnings in the Result, whose Warnings and
+	// StructuredWarnings are then empty. The warnings are the same either
+	// way.
+	OnWarning func(Warning)
+	// WarnTabIndentation reports instructions whose first line is indented
+	// with tabs, which is only a matter of style. Strict mode makes it an
+	// error.
+	WarnTabIndentation bool
+	// DisallowLegacyEnv rejects ENV instructions in the legacy space
+	// separated form, e.g. `ENV key value`, which are ambiguous, requiring
+	// `ENV key=value` instead.
+	DisallowLegacyEnv bool
+	// PreprocessLine rewrites every physical line, given with its line
+	// number, before it is parsed, e.g. to expand macros. It sees comment
+	// lines, parser directives and continuation lines, but not the lines of
+	// here-documents, and the byte order mark at the start of the file is
+	// already removed. The lines kept with RawLines and the byte offsets of
+	// the nodes are those of the lines as read.
+	PreprocessLine func(line string, lineNum int) string
+	// TrailingMeta captures a trailing comment of the form `# key: value`,
+	// e.g. `RUN build.sh # cache-id: abc`, in the Meta of the instruction and
+	// removes it from the instruction. The # must follow whitespace and not
+	// be quoted or escaped. Trailing comments of other shapes are left in the
+	// instruction, as they are by default, where the shell ignores them.
+	TrailingMeta bool
+	// WarnContinuationComments reports the comment lines found between the
+	// lines of an instruction continued with the escape token. They are
+	// dropped and the lines around them are joined, e.g. `RUN foo \`,
+	// `# comment` and `    bar` give `RUN foo     bar`, which is intended but
+	// easy to miss. Strict mode makes it an error.
+	WarnContinuationComments bool
+	// InitialDirectives sets parser directives, e.g. syntax, escape or
+	// check, as if the Dockerfile started with them. Directives in the
+	// Dockerfile override them, but still can't be repeated. The names are
+	// case-insensitive and must be known, unless CollectDirectives is set,
+	// and the values are validated like in the Dockerfile, except that the
+	// escape token must be ` or \ exactly. An escape directive takes
+	// precedence over DefaultEscapeToken.
+	InitialDirectives map[string]string
+}
+
+func (opts ParseOptions) maxLineSize() int {
+	if opts.MaxLineSize > 0 {
+		return opts.MaxLineSize
+	}
+	return bufio.MaxScanTokenSize - 1
 }
 
 // Parse reads lines from a Reader, parses the lines into an AST and returns
 // the AST and escape token
 func Parse(rwc io.Reader) (*Result, error) {
+	return ParseWithOptions(rwc, ParseOptions{})
+}
+
+// ParseString parses a Dockerfile held in a string
+func ParseString(s string) (*Result, error) {
+	return Parse(strings.NewReader(s))
+}
+
+// ParseBytes parses a Dockerfile held in a byte slice, like ParseString does
+// for a string. It reads src through a bytes.Reader and so takes the same path
+// as Parse; it is a convenience, not a faster way to parse.
+func ParseBytes(src []byte) (*Result, error) {
+	return Parse(bytes.NewReader(src))
+}
+
+// ParseFile parses the Dockerfile at path. Errors found while reading or
+// parsing it are wrapped with the path; errors.Cause returns the original
+// error, e.g. ErrNoInstructions.
+func ParseFile(path string) (*Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open dockerfile")
+	}
+	defer f.Close()
+	result, err := Parse(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", path)
+	}
+	return result, nil
+}
+
+// ParseWithOptions is like Parse but allows tuning the parser behavior
+func ParseWithOptions(rwc io.Reader, opts ParseOptions) (*Result, error) {
+	return parseWithOptions(context.Background(), rwc, opts)
+}
+
+// ParseContext is like Parse but gives up with the error of ctx once it is
+// canceled or its deadline passes, which bounds the time spent on large or
+// pathological input. The context is checked every few lines.
+func ParseContext(ctx context.Context, rwc io.Reader) (*Result, error) {
+	return parseWithOptions(ctx, rwc, ParseOptions{})
+}
+
+func parseWithOptions(ctx context.Context, rwc io.Reader, opts ParseOptions) (*Result, error) {
+	root := &Node{StartLine: -1}
+	var errs ParseErrors
+	emit := func(child *Node) {
+		root.AddChild(child, child.StartLine, child.endLine)
+	}
+	report := func(perr *ParseError) error {
+		if !opts.CollectErrors {
+			return perr
+		}
+		errs = append(errs, *perr)
+		return nil
+	}
+
+	result, err := parse(ctx, rwc, opts, emit, report)
+	if err != nil {
+		return nil, err
+	}
+	result.AST = root
+	if len(errs) > 0 {
+		return result, errs
+	}
+	return result, nil
+}
+
+// parse does the work of ParseWithOptions. Every instruction is passed to
+// emit as soon as it is complete, and every error to report, which returns
+// the error to stop parsing with, if any. The returned Result has no AST.
+func parse(ctx context.Context, rwc io.Reader, opts ParseOptions, emit func(*Node), report func(*ParseError) error) (*Result, error) {
 	d := NewDefaultDirective()
+	if opts.DefaultEscapeToken != 0 {
+		if err := d.setEscapeToken(string(opts.DefaultEscapeToken)); err != nil {
+			return nil, err
+		}
+	}
+	d.collectUnknown = opts.CollectDirectives
+	if err := d.setInitialDirectives(opts.InitialDirectives); err != nil {
+		return nil, err
+	}
+	if len(opts.Commands) > 0 {
+		d.commands = make(map[string]InstructionParser, len(opts.Commands))
+		for name, fn := range opts.Commands {
+			name = strings.ToLower(name)
+			if _, ok := dispatch[name]; ok {
+				return nil, errors.Errorf("cannot override built-in instruction %s", strings.ToUpper(name))
+			}
+			d.commands[name] = fn
+		}
+	}
 	currentLine := 0
-	root := &Node{StartLine: -1}
-	scanner := bufio.NewScanner(rwc)
-	warnings := []string{}
+	scanner := newOffsetScanner(ctx, rwc)
+	scanner.Buffer(nil, opts.maxLineSize()+1)
+	var warnings []Warning
+	var comments []string
+	var instructions int
+	var stageLine, layers int // FROM line of the current stage and its layer instructions
+	var sawFrom, reportedFrom bool
+	var sawComment bool // whether a comment line or parser directive was read
+
+	var failed bool
+	// fail records an error found on a line and returns the error to stop
+	// parsing with, if any
+	fail := func(line int, err error) error {
+		failed = true
+		return report(newParseError(line, err))
+	}
+
+	// warn records a warning found on a line, or passes it to OnWarning
+	warn := func(line int, rule string, severity Severity, msg string) {
+		w := Warning{RuleID: rule, Message: msg, Line: line, Severity: severity}
+		if opts.OnWarning != nil {
+			opts.OnWarning(w)
+			return
+		}
+		warnings = append(warnings, w)
+	}
+	// problem records a problem found in an instruction as a warning, or as
+	// an error in strict mode, unless its rule is skipped by the check
+	// parser directive
+	problem := func(line int, w Warning) error {
+		if skip := skippedRules(d.directives[directiveCheck]); skip["all"] || skip[w.RuleID] {
+			return nil
+		}
+		if !opts.Strict {
+			warn(line, w.RuleID, w.Severity, w.Message)
+			return nil
+		}
+		return fail(line, errors.New(w.Message))
+	}
+
+	// preprocess passes a physical line to PreprocessLine, if set
+	preprocess := func(line []byte, lineNum int) []byte {
+		if opts.PreprocessLine == nil {
+			return line
+		}
+		return []byte(opts.PreprocessLine(string(line), lineNum))
+	}
 
 	var err error
 	for scanner.Scan() {
@@ -228,71 +860,303 @@ func Parse(rwc io.Reader) (*Result, error) {
 			// First line, strip the byte-order-marker if present
 			bytesRead = bytes.TrimPrefix(bytesRead, utf8bom)
 		}
+		var rawLines []string
+		if opts.RawLines {
+			rawLines = []string{string(bytesRead)}
+		}
+		// the offset of the instruction in the line as read, following the
+		// byte order mark and the indentation, as PreprocessLine may change
+		// the line
+		bom := len(scanner.Bytes()) - len(bytesRead)
+		startByte := scanner.offset + bom + len(bytesRead) - len(trimWhitespace(bytesRead))
+		bytesRead = preprocess(bytesRead, currentLine+1)
+		if isComment(bytesRead) {
+			sawComment = true
+		}
+		var comment string
+		if (opts.PreserveComments || opts.Lossless) && isComment(bytesRead) {
+			comment = string(trimWhitespace(bytesRead))
+		}
+		currentLine++
+		if instructions > 0 {
+			if name := misplacedDirective(bytesRead); name != "" {
+				warn(currentLine, RuleMisplacedParserDirective, SeverityWarning, fmt.Sprintf("the %s parser directive is ignored, parser directives must precede any instruction", name))
+			}
+		}
+		indent := bytesRead[:len(bytesRead)-len(trimWhitespace(bytesRead))]
 		bytesRead, err = processLine(d, bytesRead, true)
 		if err != nil {
-			return nil, err
+			if err := fail(currentLine, err); err != nil {
+				return nil, err
+			}
+		}
+		// parser directives leave directive processing incomplete
+		if comment != "" && d.processingComplete && !opts.Lossless {
+			comments = append(comments, comment)
 		}
-		currentLine++
 
 		startLine := currentLine
+		offsets := []lineOffset{{pos: 0, offset: startByte}}
 		line, isEndOfLine := continuateLine(string(bytesRead), d)
 		if isEndOfLine && line == "" {
+			if opts.Lossless && (comment == "" || d.processingComplete) {
+				emit(newLosslessNode(comment, currentLine, scanner))
+			}
 			continue
 		}
+		if opts.WarnTabIndentation && bytes.IndexByte(indent, '\t') >= 0 {
+			if err := problem(startLine, Warning{RuleID: RuleTabIndentation, Message: "instruction is indented with tabs", Severity: SeverityInfo}); err != nil {
+				return nil, err
+			}
+		}
 
-		var hasEmptyContinuationLine bool
+		var emptyContinuationLine int // first empty continuation line, if any
 		for !isEndOfLine && scanner.Scan() {
-			bytesRead, err := processLine(d, scanner.Bytes(), false)
+			currentLine++
+			if opts.RawLines {
+				rawLines = append(rawLines, scanner.Text())
+			}
+			physical := preprocess(scanner.Bytes(), currentLine)
+			bytesRead, err := processLine(d, physical, false)
 			if err != nil {
-				return nil, err
+				if err := fail(currentLine, err); err != nil {
+					return nil, err
+				}
 			}
-			currentLine++
 
-			if isComment(scanner.Bytes()) {
+			if isComment(physical) {
 				// original line was a comment (processLine strips comments)
+				if opts.WarnContinuationComments {
+					msg := fmt.Sprintf("comment inside the instruction starting on line %d is dropped", startLine)
+					if err := problem(currentLine, Warning{RuleID: RuleCommentInContinuation, Message: msg, Severity: SeverityInfo}); err != nil {
+						return nil, err
+					}
+				}
 				continue
 			}
 			if isEmptyContinuationLine(bytesRead) {
-				hasEmptyContinuationLine = true
+				if emptyContinuationLine == 0 {
+					emptyContinuationLine = currentLine
+				}
 				continue
 			}
 
+			offsets = append(offsets, lineOffset{pos: len(line), offset: scanner.offset})
 			continuationLine := string(bytesRead)
 			line, isEndOfLine = continuateLine(line+continuationLine, d)
 		}
+		// a JSON array on a single line that isn't closed has always been
+		// parsed in shell form, only one continued to the end of the file is
+		// an error
+		if !isEndOfLine && currentLine > startLine && scanner.Err() == nil && isUnterminatedJSONArray(line) {
+			if err := fail(startLine, errors.Errorf("unterminated JSON array started at line %d", startLine)); err != nil {
+				return nil, err
+			}
+			continue
+		}
 
-		if hasEmptyContinuationLine {
-			warnings = append(warnings, "[WARNING]: Empty continuation line found in:\n    "+line)
+		if emptyContinuationLine > 0 {
+			if opts.EmptyContinuationLineError {
+				if err := fail(emptyContinuationLine, errors.New("empty continuation line found in:\n    "+line)); err != nil {
+					return nil, err
+				}
+			} else {
+				warn(emptyContinuationLine, RuleNoEmptyContinuation, SeverityWarning, "Empty continuation line found in:\n    "+line)
+			}
 		}
 
+		heredocs := heredocsFromLine(line, d)
+		if len(heredocs) > 0 {
+			n, err := readHeredocs(scanner, heredocs)
+			currentLine += n
+			if scanner.Err() != nil {
+				break
+			}
+			if err != nil {
+				if err := fail(startLine, err); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if scanner.Err() != nil {
+			// the instruction may be incomplete
+			break
+		}
+		var meta map[string]string
+		if opts.TrailingMeta {
+			line, meta = splitTrailingMeta(line, d.escapeToken)
+		}
 		child, err := newNodeFromLine(line, d)
 		if err != nil {
-			return nil, err
+			if err := fail(startLine, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if opts.DisallowLegacyEnv && strings.EqualFold(child.Value, command.Env) {
+			if _, legacy := legacyKeyValue(child, d); legacy {
+				if err := fail(startLine, errors.New(`ENV must be of the form ENV key=value, the legacy "ENV key value" form is not allowed`)); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+		child.Heredocs = heredocs
+		child.RawLines = rawLines
+		child.Meta = meta
+		for _, w := range checkInstruction(child, d) {
+			if err := problem(startLine, w); err != nil {
+				return nil, err
+			}
+		}
+		switch cmd := strings.ToLower(child.Value); {
+		case cmd == command.From:
+			sawFrom = true
+		case !sawFrom && !reportedFrom && cmd != command.Arg:
+			reportedFrom = true
+			msg := fmt.Sprintf("%s can't precede the first FROM, only ARG instructions can", strings.ToUpper(cmd))
+			if err := problem(startLine, Warning{RuleID: RuleInstructionBeforeFrom, Message: msg, Severity: SeverityWarning}); err != nil {
+				return nil, err
+			}
+		}
+		if opts.MaxLayerInstructions > 0 {
+			switch strings.ToLower(child.Value) {
+			case command.From:
+				stageLine, layers = startLine, 0
+			case command.Run, command.Copy, command.Add:
+				layers++
+				if layers == opts.MaxLayerInstructions+1 {
+					msg := fmt.Sprintf("stage has more than %d RUN, COPY and ADD instructions", opts.MaxLayerInstructions)
+					if stageLine > 0 {
+						msg = fmt.Sprintf("stage starting on line %d has more than %d RUN, COPY and ADD instructions", stageLine, opts.MaxLayerInstructions)
+					}
+					if err := problem(startLine, Warning{RuleID: RuleMaxLayerInstructions, Message: msg, Severity: SeverityWarning}); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+		if !opts.AllowMaintainer {
+			if deprecation := checkDeprecated(child); deprecation != "" {
+				warn(startLine, RuleMaintainerDeprecated, SeverityWarning, deprecation)
+			}
+		}
+		if opts.Normalize {
+			if casing := checkCasing(child); casing != "" {
+				warn(startLine, RuleConsistentInstructionCasing, SeverityInfo, casing)
+			}
+			child.normalize()
 		}
-		root.AddChild(child, startLine, currentLine)
+		if opts.CanonicalWhitespace {
+			child.canonicalizeWhitespace(d.escapeToken)
+		}
+		child.StartByte, child.EndByte, child.offsets = startByte, scanner.end(), offsets
+		child.Comments, comments = comments, nil
+		child.lines(startLine, currentLine)
+		instructions++
+		emit(child)
 	}
 
-	if len(warnings) > 0 {
-		warnings = append(warnings, "[WARNING]: Empty continuation lines will become errors in a future release.")
+	if err := scanner.Err(); err != nil {
+		return nil, handleScannerError(err, opts.maxLineSize())
 	}
 
-	if root.StartLine < 0 {
-		return nil, errors.New("file with no instructions.")
+	if instructions == 0 && !failed {
+		if sawComment {
+			return nil, ErrOnlyComments
+		}
+		return nil, ErrNoInstructions
 	}
 
 	return &Result{
-		AST:         root,
-		Warnings:    warnings,
+		Warnings:    warningStrings(warnings),
 		EscapeToken: d.escapeToken,
-	}, handleScannerError(scanner.Err())
+		Syntax:      d.directives[directiveSyntax],
+		Check:       d.directives[directiveCheck],
+		Directives:  d.Directives(),
+
+		StructuredWarnings: warnings,
+		TrailingComments:   comments,
+		LineCount:          currentLine,
+	}, nil
+}
+
+// Values of the nodes added for the lines that are not instructions in
+// lossless mode. They can't clash with instructions, which don't start
+// with #.
+const (
+	BlankLineNode = "#blank"
+	CommentNode   = "#comment"
+)
+
+// newLosslessNode returns the node for a blank line, or for a comment line
+// if comment is set
+func newLosslessNode(comment string, line int, scanner *offsetScanner) *Node {
+	node := &Node{Value: BlankLineNode}
+	if comment != "" {
+		node.Value, node.Original = CommentNode, comment
+	}
+	node.lines(line, line)
+	node.StartByte, node.EndByte = scanner.offset, scanner.end()
+	return node
+}
+
+// offsetScanner is a bufio.Scanner over lines that keeps track of the byte
+// offset of the current line in the input.
+type offsetScanner struct {
+	*bufio.Scanner
+	ctx    context.Context
+	lines  int // number of lines scanned
+	offset int // offset of the current line
+	next   int // offset of the line following the current one
+}
+
+// ctxCheckInterval is the number of lines scanned between checks of the
+// context
+const ctxCheckInterval = 64
+
+func newOffsetScanner(ctx context.Context, r io.Reader) *offsetScanner {
+	s := &offsetScanner{Scanner: bufio.NewScanner(r), ctx: ctx}
+	s.Split(s.scanLines)
+	return s
+}
+
+func (s *offsetScanner) scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	if token != nil {
+		if s.lines%ctxCheckInterval == 0 {
+			if err := s.ctx.Err(); err != nil {
+				return 0, nil, err
+			}
+		}
+		s.lines++
+		s.offset = s.next
+		s.next += advance
+	}
+	return advance, token, err
+}
+
+// end returns the offset right after the content of the current line,
+// excluding the carriage returns at its end
+func (s *offsetScanner) end() int {
+	return s.offset + len(bytes.TrimRight(s.Bytes(), "\r"))
 }
 
+// trimComments removes a comment line. Only lines starting with # are
+// comments, a # later on the line, quoted or not, is part of the arguments
+// and left for the shell or the instruction to interpret.
 func trimComments(src []byte) []byte {
-	return tokenComment.ReplaceAll(src, []byte{})
+	// lines never hold a newline, so this is what replacing tokenComment
+	// does, without copying the line
+	if len(src) > 0 && src[0] == '#' {
+		return src[:0]
+	}
+	return src
 }
 
 func trimWhitespace(src []byte) []byte {
-	return bytes.TrimLeftFunc(src, unicode.IsSpace)
+	return bytes.TrimLeft(src, whitespace)
 }
 
 func isComment(line []byte) bool {
@@ -305,31 +1169,86 @@ func isEmptyContinuationLine(line []byte) bool {
 
 var utf8bom = []byte{0xEF, 0xBB, 0xBF}
 
+// continuateLine removes the escape token continuing line on the next one,
+// if any, and reports whether the instruction ends with line. A line ending
+// in the escape token always continues, and so does a JSON array that isn't
+// closed yet, so both can be mixed within one array.
 func continuateLine(line string, d *Directive) (string, bool) {
 	if d.lineEscapeRegex.MatchString(line) {
 		line = d.lineEscapeRegex.ReplaceAllString(line, "")
 		return line, false
 	}
-	if lineJSONArrayContinuator.MatchString(line) {
+	if hasUnclosedJSONArray(line) {
 		return line, false
 	}
 
 	return line, true
 }
 
+// hasUnclosedJSONArray reports whether line has a `[` outside of a double
+// quoted string that isn't closed, which continues a JSON array on the next
+// line. Quotes can be escaped inside strings with a backslash.
+func hasUnclosedJSONArray(line string) bool {
+	depth, _ := jsonArrayDepth(line)
+	return depth > 0
+}
+
+// jsonArrayDepth returns the number of unclosed `[` outside of double quoted
+// strings on line, and whether line ends inside a string
+func jsonArrayDepth(line string) (depth int, inString bool) {
+	var escaped bool
+	for _, ch := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			switch ch {
+			case '\\':
+				escaped = true
+			case '"':
+				inString = false
+			}
+		case ch == '"':
+			inString = true
+		case ch == '[':
+			depth++
+		case ch == ']' && depth > 0:
+			depth--
+		}
+	}
+	return depth, inString
+}
+
+// isUnterminatedJSONArray reports whether the arguments of the instruction
+// on line are a JSON array that isn't closed. Shell-form arguments with an
+// unclosed [ are not, nor are arguments ending in an unterminated string,
+// which are parsed in shell form.
+func isUnterminatedJSONArray(line string) bool {
+	if depth, inString := jsonArrayDepth(line); depth == 0 || inString {
+		return false
+	}
+	_, _, args, err := splitCommand(line)
+	return err == nil && strings.HasPrefix(args, "[")
+}
+
 // TODO: remove stripLeftWhitespace after deprecation period. It seems silly
 // to preserve whitespace on continuation lines. Why is that done?
 func processLine(d *Directive, token []byte, stripLeftWhitespace bool) ([]byte, error) {
+	// The scanner drops the CR of a CRLF line ending, but a stray one left
+	// at the end of the line would still hide a trailing escape token.
+	// Only the end of the line is trimmed, so a CR inside a quoted argument
+	// is kept.
+	token = bytes.TrimRight(token, "\r")
 	if stripLeftWhitespace {
 		token = trimWhitespace(token)
 	}
 	return trimComments(token), d.possibleParserDirective(string(token))
 }
 
-func handleScannerError(err error) error {
+func handleScannerError(err error, maxLineSize int) error {
 	switch err {
 	case bufio.ErrTooLong:
-		return errors.Errorf("dockerfile line greater than max allowed size of %d", bufio.MaxScanTokenSize-1)
+		return errors.Errorf("dockerfile line greater than max allowed size of %d", maxLineSize)
 	default:
 		return err
 	}
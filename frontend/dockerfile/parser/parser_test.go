@@ -3,14 +3,20 @@ package parser
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
+	pkgerrors "github.com/pkg/errors"
 	"gotest.tools/assert"
 	is "gotest.tools/assert/cmp"
 )
@@ -65,6 +71,50 @@ func TestParseCases(t *testing.T) {
 	}
 }
 
+func TestParseString(t *testing.T) {
+	result, err := ParseString("FROM busybox\nRUN echo hello\n")
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(result.AST.Children, 2))
+}
+
+func TestParseBytes(t *testing.T) {
+	dirs, err := ioutil.ReadDir(testDir)
+	assert.NilError(t, err)
+	for _, dir := range dirs {
+		src, err := ioutil.ReadFile(filepath.Join(testDir, dir.Name(), "Dockerfile"))
+		assert.NilError(t, err)
+
+		expected, err := Parse(bytes.NewReader(src))
+		assert.NilError(t, err)
+		result, err := ParseBytes(src)
+		assert.NilError(t, err)
+		assert.Check(t, reflect.DeepEqual(expected, result), dir.Name())
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	result, err := ParseFile(testFileLineInfo)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(result.AST.Children, 3))
+
+	missing := filepath.Join(testDir, "missing", "Dockerfile")
+	_, err = ParseFile(missing)
+	assert.Check(t, is.ErrorContains(err, "failed to open dockerfile"))
+	assert.Check(t, is.ErrorContains(err, missing))
+
+	_, err = ParseFile(testDir)
+	assert.Check(t, is.ErrorContains(err, "failed to parse "+testDir+": "))
+
+	invalid := filepath.Join(negativeTestDir, "env_no_value", "Dockerfile")
+	_, err = ParseFile(invalid)
+	assert.Check(t, is.ErrorContains(err, "failed to parse "+invalid+": Dockerfile parse error line 3: "))
+
+	empty := filepath.Join(negativeTestDir, "empty_dockerfile", "Dockerfile")
+	_, err = ParseFile(empty)
+	assert.Check(t, is.ErrorContains(err, empty))
+	assert.Check(t, is.Equal(pkgerrors.Cause(err), ErrNoInstructions))
+}
+
 func TestParseWords(t *testing.T) {
 	tests := []map[string][]string{
 		{
@@ -130,6 +180,282 @@ func TestParseIncludesLineNumbers(t *testing.T) {
 	}
 }
 
+func TestParseEndLine(t *testing.T) {
+	dockerfile := strings.NewReader(`FROM busybox
+RUN echo foo \
+    bar \
+    baz
+ONBUILD RUN make \
+    install
+`)
+	result, err := Parse(dockerfile)
+	assert.NilError(t, err)
+
+	ast := result.AST
+	assert.Check(t, is.Equal(1, ast.StartLine))
+	assert.Check(t, is.Equal(6, ast.EndLine()))
+	assert.Assert(t, is.Len(ast.Children, 3))
+
+	run := ast.Children[1]
+	assert.Check(t, is.DeepEqual([]int{2, 4}, []int{run.StartLine, run.EndLine()}))
+
+	onbuild := ast.Children[2]
+	assert.Check(t, is.DeepEqual([]int{5, 6}, []int{onbuild.StartLine, onbuild.EndLine()}))
+	trigger := onbuild.Next.Children[0]
+	assert.Check(t, is.DeepEqual([]int{5, 6}, []int{trigger.StartLine, trigger.EndLine()}))
+}
+
+func TestParseByteOffsets(t *testing.T) {
+	dockerfile := "\xEF\xBB\xBF# escape=`\nFROM busybox\n\n  RUN echo `\n    foo `\n# comment\n    bar\n"
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(result.AST.Children, 2))
+
+	from := result.AST.Children[0]
+	assert.Check(t, is.Equal(14, from.StartByte))
+	assert.Check(t, is.Equal(26, from.EndByte))
+	assert.Check(t, is.Equal("FROM busybox", dockerfile[from.StartByte:from.EndByte]))
+
+	run := result.AST.Children[1]
+	assert.Check(t, is.Equal("RUN echo     foo     bar", run.Original))
+	assert.Check(t, is.Equal(strings.Index(dockerfile, "RUN"), run.StartByte))
+	assert.Check(t, is.Equal(len(dockerfile)-1, run.EndByte))
+
+	for _, word := range []string{"echo", "foo", "bar"} {
+		offset := run.SourceOffset(strings.Index(run.Original, word))
+		assert.Check(t, is.Equal(word, dockerfile[offset:offset+len(word)]))
+	}
+}
+
+func TestParseByteOffsetsCarriageReturns(t *testing.T) {
+	dockerfile := "FROM a\nRUN x\r\r\nRUN y\n"
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(result.AST.Children, 3))
+
+	run := result.AST.Children[1]
+	assert.Check(t, is.Equal(7, run.StartByte))
+	assert.Check(t, is.Equal("RUN x", dockerfile[run.StartByte:run.EndByte]))
+	last := result.AST.Children[2]
+	assert.Check(t, is.Equal("RUN y", dockerfile[last.StartByte:last.EndByte]))
+}
+
+func TestParseEmptyDirective(t *testing.T) {
+	// like before directives were generalized, lines without a value are
+	// plain comments
+	for _, dockerfile := range []string{
+		"# escape=\nFROM a\n",
+		"# escape= \t\nFROM a\n",
+		"# syntax=\nFROM a\n",
+		"# check=\nFROM a\n",
+	} {
+		result, err := Parse(strings.NewReader(dockerfile))
+		assert.NilError(t, err, dockerfile)
+		assert.Check(t, is.Equal(DefaultEscapeToken, result.EscapeToken), dockerfile)
+		assert.Check(t, is.Len(result.Directives, 0), dockerfile)
+	}
+}
+
+func TestParseSyntaxDirective(t *testing.T) {
+	for _, dockerfile := range []string{
+		"# syntax=docker/dockerfile:1.4\n# escape=`\nFROM busybox\nRUN echo `\n  foo\n",
+		"# escape=`\n#SYNTAX = docker/dockerfile:1.4 \nFROM busybox\nRUN echo `\n  foo\n",
+	} {
+		result, err := Parse(strings.NewReader(dockerfile))
+		assert.NilError(t, err)
+		assert.Check(t, is.Equal("docker/dockerfile:1.4", result.Syntax))
+		assert.Check(t, is.Equal('`', result.EscapeToken))
+		assert.Check(t, is.Len(result.AST.Children, 2))
+	}
+
+	_, err := Parse(strings.NewReader("# syntax=a\n# syntax=b\nFROM busybox\n"))
+	assert.Check(t, is.ErrorContains(err, "only one syntax parser directive can be used"))
+
+	result, err := Parse(strings.NewReader("FROM busybox\n# syntax=docker/dockerfile:1.4\n"))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("", result.Syntax))
+}
+
+func TestParseCheckDirective(t *testing.T) {
+	result, err := Parse(strings.NewReader("# syntax=docker/dockerfile:1\n# check=skip=all\nFROM busybox\n"))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("skip=all", result.Check))
+
+	result, err = Parse(strings.NewReader("# check = skip=StageNameCasing,JSONArgsRecommended;error=true\nFROM busybox\n"))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("skip=StageNameCasing,JSONArgsRecommended;error=true", result.Check))
+
+	// without a value it is a plain comment, like any directive
+	result, err = Parse(strings.NewReader("# check=\nFROM busybox\n"))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("", result.Check))
+
+	for _, dockerfile := range []string{
+		"# check=skip\nFROM busybox\n",
+		"# check=skip=all;\nFROM busybox\n",
+	} {
+		_, err = Parse(strings.NewReader(dockerfile))
+		assert.Check(t, is.ErrorContains(err, "invalid check parser directive"), dockerfile)
+	}
+
+	_, err = Parse(strings.NewReader("# check=skip=all\n# check=error=true\nFROM busybox\n"))
+	assert.Check(t, is.ErrorContains(err, "only one check parser directive can be used"))
+
+	result, err = Parse(strings.NewReader("FROM busybox\n# check=skip=all\n"))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("", result.Check))
+}
+
+func TestDirectiveIsLineContinued(t *testing.T) {
+	d := NewDefaultDirective()
+	assert.Check(t, is.Equal('\\', d.EscapeToken()))
+	assert.Check(t, d.IsLineContinued(`RUN echo \`))
+	assert.Check(t, d.IsLineContinued("RUN echo \\ \t"))
+	assert.Check(t, !d.IsLineContinued("RUN echo `"))
+	assert.Check(t, !d.IsLineContinued(`RUN echo \ foo`))
+
+	assert.NilError(t, d.possibleParserDirective("# escape=`"))
+	assert.Check(t, is.Equal('`', d.EscapeToken()))
+	assert.Check(t, d.IsLineContinued("COPY c:\\src c:\\dst `"))
+	assert.Check(t, !d.IsLineContinued(`COPY c:\src c:\dst\`))
+}
+
+func TestParseCollectDirectives(t *testing.T) {
+	dockerfile := "# syntax=docker/dockerfile:1\n# MyTool=foo\n# escape=`\n# other = bar baz\n\nFROM busybox\nRUN echo `\n  hello\n"
+	result, err := ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{CollectDirectives: true})
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(map[string]string{
+		"syntax": "docker/dockerfile:1",
+		"mytool": "foo",
+		"escape": "`",
+		"other":  "bar baz",
+	}, result.Directives))
+	assert.Check(t, is.Equal('`', result.EscapeToken))
+	assert.Check(t, is.Len(result.AST.Children, 2))
+
+	buf := &bytes.Buffer{}
+	assert.NilError(t, result.Unparse(buf))
+	reparsed, err := ParseWithOptions(buf, ParseOptions{CollectDirectives: true})
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(result.Directives, reparsed.Directives))
+
+	// unknown directives end directive processing by default
+	result, err = Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(map[string]string{"syntax": "docker/dockerfile:1"}, result.Directives))
+	assert.Check(t, is.Equal('\\', result.EscapeToken))
+}
+
+func TestStripDirectives(t *testing.T) {
+	dockerfile := "# escape=`\r\n# syntax=docker/dockerfile:1\nFROM windows\n# escape=\\\nRUN dir\n"
+	directives, body, err := StripDirectives(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(map[string]string{
+		"escape": "`",
+		"syntax": "docker/dockerfile:1",
+	}, directives))
+	rest, err := ioutil.ReadAll(body)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("FROM windows\n# escape=\\\nRUN dir\n", string(rest)))
+
+	directives, body, err = StripDirectives(strings.NewReader("FROM busybox\n"))
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(directives, 0))
+	rest, err = ioutil.ReadAll(body)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("FROM busybox\n", string(rest)))
+
+	directives, body, err = StripDirectives(strings.NewReader("# syntax=docker/dockerfile:1"))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(map[string]string{"syntax": "docker/dockerfile:1"}, directives))
+	rest, err = ioutil.ReadAll(body)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(rest, 0))
+
+	_, _, err = StripDirectives(strings.NewReader("# escape=x\nFROM busybox\n"))
+	assert.Check(t, is.ErrorContains(err, "Dockerfile parse error line 1: invalid ESCAPE 'x'"))
+}
+
+func TestParserDirectives(t *testing.T) {
+	d := NewDefaultDirective()
+	assert.NilError(t, d.possibleParserDirective("# ESCAPE = `  "))
+	assert.NilError(t, d.possibleParserDirective("#Syntax=\tdocker/dockerfile:1.4\t"))
+	assert.Check(t, is.Equal('`', d.escapeToken))
+	assert.Check(t, is.DeepEqual(map[string]string{
+		"escape": "`",
+		"syntax": "docker/dockerfile:1.4",
+	}, d.Directives()))
+
+	err := d.possibleParserDirective("# escape=\\")
+	assert.Check(t, is.Error(err, "only one escape parser directive can be used"))
+
+	d.Directives()["escape"] = "modified"
+	assert.Check(t, is.Equal("`", d.Directives()["escape"]))
+
+	assert.NilError(t, d.possibleParserDirective("# not a directive"))
+	assert.NilError(t, d.possibleParserDirective("# syntax=ignored"))
+	assert.Check(t, is.Equal("docker/dockerfile:1.4", d.Directives()["syntax"]))
+}
+
+func TestParsePreserveComments(t *testing.T) {
+	dockerfile := `# escape=\\
+
+# base image
+FROM busybox
+# first line
+  # second line
+
+# third line
+RUN echo foo
+# trailing comment
+`
+	result, err := ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{PreserveComments: true})
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(result.AST.Children, 2))
+	assert.Check(t, is.DeepEqual([]string{"# base image"}, result.AST.Children[0].Comments))
+	assert.Check(t, is.DeepEqual([]string{"# first line", "# second line", "# third line"}, result.AST.Children[1].Comments))
+	assert.Check(t, is.DeepEqual([]string{"# trailing comment"}, result.TrailingComments))
+
+	buf := &bytes.Buffer{}
+	assert.NilError(t, result.Unparse(buf))
+	assert.Check(t, is.Equal(`# base image
+FROM busybox
+# first line
+# second line
+# third line
+RUN echo foo
+# trailing comment
+`, buf.String()))
+
+	result, err = Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(result.AST.Children[1].Comments, 0))
+	assert.Check(t, is.Len(result.TrailingComments, 0))
+}
+
+func TestParseRawLines(t *testing.T) {
+	dockerfile := "FROM busybox\n# not part of RUN\nRUN apt-get update \\\n  # install curl\n  && apt-get install -y curl \\\n\n  && rm -rf /var/lib/apt/lists\nCMD [\"curl\"]\n"
+	result, err := ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{RawLines: true})
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(result.AST.Children, 3))
+
+	run := result.AST.Children[1]
+	assert.Check(t, is.DeepEqual([]string{
+		"RUN apt-get update \\",
+		"  # install curl",
+		"  && apt-get install -y curl \\",
+		"",
+		"  && rm -rf /var/lib/apt/lists",
+	}, run.RawLines))
+	assert.Check(t, is.Equal("RUN apt-get update   && apt-get install -y curl   && rm -rf /var/lib/apt/lists", run.Original))
+	assert.Check(t, is.DeepEqual([]string{"FROM busybox"}, result.AST.Children[0].RawLines))
+	assert.Check(t, is.DeepEqual([]string{`CMD ["curl"]`}, result.AST.Children[2].RawLines))
+
+	result, err = Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(result.AST.Children[1].RawLines, 0))
+}
+
 func TestParseWarnsOnEmptyContinutationLine(t *testing.T) {
 	dockerfile := bytes.NewBufferString(`
 FROM alpine:3.6
@@ -156,10 +482,237 @@ RUN indented \
 	assert.NilError(t, err)
 	warnings := result.Warnings
 	assert.Check(t, is.Len(warnings, 3))
-	assert.Check(t, is.Contains(warnings[0], "Empty continuation line found in"))
-	assert.Check(t, is.Contains(warnings[0], "RUN something     following     more"))
-	assert.Check(t, is.Contains(warnings[1], "RUN another     thing"))
+	assert.Check(t, is.Equal("[WARNING]: line 5: Empty continuation line found in:\n    RUN something     following     more", warnings[0]))
+	assert.Check(t, is.Equal("[WARNING]: line 11: Empty continuation line found in:\n    RUN another     thing", warnings[1]))
 	assert.Check(t, is.Contains(warnings[2], "will become errors in a future release"))
+
+	structured := result.StructuredWarnings
+	assert.Check(t, is.Len(structured, 2))
+	assert.Check(t, is.Equal(RuleNoEmptyContinuation, structured[0].RuleID))
+	assert.Check(t, is.Equal(SeverityWarning, structured[0].Severity))
+	assert.Check(t, is.Equal(5, structured[0].Line))
+	assert.Check(t, is.Equal(RuleNoEmptyContinuation, structured[1].RuleID))
+	assert.Check(t, is.Equal(11, structured[1].Line))
+}
+
+func TestParseOnWarning(t *testing.T) {
+	dockerfile := "FROM alpine\nRUN something \\\n\n    following\nRUN another \\\n\n    thing\nMAINTAINER me\n"
+	expected, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(expected.StructuredWarnings, 3))
+
+	var warnings []Warning
+	var emptyContinuationLines int
+	result, err := ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{
+		OnWarning: func(w Warning) {
+			warnings = append(warnings, w)
+			if w.RuleID == RuleNoEmptyContinuation {
+				emptyContinuationLines++
+			}
+		},
+	})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(2, emptyContinuationLines))
+	assert.Check(t, is.DeepEqual(expected.StructuredWarnings, warnings))
+	assert.Check(t, is.Len(result.Warnings, 0))
+	assert.Check(t, is.Len(result.StructuredWarnings, 0))
+}
+
+func TestParsePreprocessLine(t *testing.T) {
+	dockerfile := "#escape-backtick\nFROM alpine\nRUN legacy\nRUN echo `\n  RUN legacy\n"
+	var lineNums []int
+	result, err := ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{
+		RawLines: true,
+		PreprocessLine: func(line string, lineNum int) string {
+			lineNums = append(lineNums, lineNum)
+			switch strings.TrimSpace(line) {
+			case "#escape-backtick":
+				return "# escape=`"
+			case "RUN legacy":
+				return strings.Replace(line, "legacy", "new", 1)
+			}
+			return line
+		},
+	})
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]int{1, 2, 3, 4, 5}, lineNums))
+	assert.Check(t, is.Equal('`', result.EscapeToken))
+
+	children := result.AST.Children
+	assert.Assert(t, is.Len(children, 3))
+	assert.Check(t, is.DeepEqual([]string{"run", "new"}, nodeValues(children[1])))
+	assert.Check(t, is.Equal("RUN new", children[1].Original))
+	assert.Check(t, is.DeepEqual([]string{"RUN legacy"}, children[1].RawLines))
+	// offsets are those of the lines as read, whose length changed
+	assert.Check(t, is.Equal(strings.Index(dockerfile, "RUN legacy"), children[1].StartByte))
+	assert.Check(t, is.Equal(strings.Index(dockerfile, "RUN echo"), children[2].StartByte))
+	assert.Check(t, is.DeepEqual([]string{"run", "echo   RUN new"}, nodeValues(children[2])))
+}
+
+func TestParseTrailingMeta(t *testing.T) {
+	dockerfile := `FROM alpine
+RUN build.sh # cache-id: abc
+RUN echo "# cache-id: abc" # not metadata
+RUN echo a \# cache-id: abc
+RUN make \
+    install  #  owner: team build
+`
+	result, err := ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{TrailingMeta: true})
+	assert.NilError(t, err)
+	children := result.AST.Children
+	assert.Assert(t, is.Len(children, 5))
+
+	assert.Check(t, is.DeepEqual(map[string]string{"cache-id": "abc"}, children[1].Meta))
+	assert.Check(t, is.DeepEqual([]string{"run", "build.sh"}, nodeValues(children[1])))
+	assert.Check(t, is.Equal("RUN build.sh", children[1].Original))
+
+	assert.Check(t, is.Nil(children[2].Meta))
+	assert.Check(t, is.DeepEqual([]string{"run", `echo "# cache-id: abc" # not metadata`}, nodeValues(children[2])))
+	assert.Check(t, is.Nil(children[3].Meta))
+	assert.Check(t, is.DeepEqual(map[string]string{"owner": "team build"}, children[4].Meta))
+	assert.Check(t, is.DeepEqual([]string{"run", "make     install"}, nodeValues(children[4])))
+
+	result, err = Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.Nil(result.AST.Children[1].Meta))
+	assert.Check(t, is.DeepEqual([]string{"run", "build.sh # cache-id: abc"}, nodeValues(result.AST.Children[1])))
+}
+
+func TestParseWarnContinuationComments(t *testing.T) {
+	dockerfile := "FROM alpine\nRUN foo \\\n# comment\n  # indented\n bar\n# not continued\nRUN baz\n"
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{"run", "foo  bar"}, nodeValues(result.AST.Children[1])))
+	assert.Check(t, is.Len(result.StructuredWarnings, 0))
+
+	result, err = ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{WarnContinuationComments: true})
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{"run", "foo  bar"}, nodeValues(result.AST.Children[1])))
+	assert.Check(t, is.DeepEqual([]Warning{
+		{RuleID: RuleCommentInContinuation, Message: "comment inside the instruction starting on line 2 is dropped", Line: 3, Severity: SeverityInfo},
+		{RuleID: RuleCommentInContinuation, Message: "comment inside the instruction starting on line 2 is dropped", Line: 4, Severity: SeverityInfo},
+	}, result.StructuredWarnings))
+
+	_, err = ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{WarnContinuationComments: true, Strict: true})
+	assert.Check(t, is.ErrorContains(err, "comment inside the instruction starting on line 2 is dropped"))
+}
+
+func TestParseInitialDirectives(t *testing.T) {
+	opts := ParseOptions{InitialDirectives: map[string]string{
+		"Syntax": "docker/dockerfile:1",
+		"check":  "skip=all",
+	}}
+	result, err := ParseWithOptions(strings.NewReader("FROM alpine\n"), opts)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("docker/dockerfile:1", result.Syntax))
+	assert.Check(t, is.Equal("skip=all", result.Check))
+	assert.Check(t, is.Equal(DefaultEscapeToken, result.EscapeToken))
+
+	result, err = ParseWithOptions(strings.NewReader("# syntax=docker/dockerfile:1.4\nFROM alpine\n"), opts)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("docker/dockerfile:1.4", result.Syntax))
+	assert.Check(t, is.Equal("skip=all", result.Check))
+
+	_, err = ParseWithOptions(strings.NewReader("# syntax=a\n# syntax=b\nFROM alpine\n"), opts)
+	assert.Check(t, is.ErrorContains(err, "only one syntax parser directive can be used"))
+
+	result, err = ParseWithOptions(strings.NewReader("FROM alpine\nRUN dir C:\\\n"), ParseOptions{
+		InitialDirectives: map[string]string{"escape": "`"},
+	})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal('`', result.EscapeToken))
+	assert.Check(t, is.DeepEqual([]string{"run", "dir C:\\"}, nodeValues(result.AST.Children[1])))
+
+	for _, directives := range []map[string]string{
+		{"escape": "x"},
+		{"check": "skip"},
+		{"unknown": "value"},
+	} {
+		_, err := ParseWithOptions(strings.NewReader("FROM alpine\n"), ParseOptions{InitialDirectives: directives})
+		assert.Check(t, err != nil, "%v", directives)
+	}
+}
+
+func TestParseWarnTabIndentation(t *testing.T) {
+	dockerfile := "FROM busybox\n\tRUN make\n  RUN make \\\n\tinstall\n \t# comment\n \tRUN true\n"
+	result, err := ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{WarnTabIndentation: true})
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]Warning{
+		{RuleID: RuleTabIndentation, Message: "instruction is indented with tabs", Line: 2, Severity: SeverityInfo},
+		{RuleID: RuleTabIndentation, Message: "instruction is indented with tabs", Line: 6, Severity: SeverityInfo},
+	}, result.StructuredWarnings))
+
+	result, err = Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(result.Warnings, 0))
+
+	_, err = ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{WarnTabIndentation: true, Strict: true})
+	assert.Check(t, is.Error(err, "Dockerfile parse error line 2: instruction is indented with tabs"))
+}
+
+func TestParseStructuredWarnings(t *testing.T) {
+	dockerfile := "FROM busybox\nMAINTAINER me\nCopy a /b\nEXPOSE 80/tcpx\n"
+	result, err := ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{Normalize: true})
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]Warning{
+		{RuleID: RuleMaintainerDeprecated, Message: `MAINTAINER instruction is deprecated, use LABEL maintainer="name" instead`, Line: 2, Severity: SeverityWarning},
+		{RuleID: RuleConsistentInstructionCasing, Message: "instruction Copy should be either all uppercase or all lowercase", Line: 3, Severity: SeverityInfo},
+		{RuleID: RuleInvalidExposePort, Message: `invalid EXPOSE port "80/tcpx": unknown protocol "tcpx"`, Line: 4, Severity: SeverityWarning},
+	}, result.StructuredWarnings))
+	assert.Check(t, is.DeepEqual([]string{
+		`[WARNING]: line 2: MAINTAINER instruction is deprecated, use LABEL maintainer="name" instead`,
+		"[WARNING]: line 3: instruction Copy should be either all uppercase or all lowercase",
+		`[WARNING]: line 4: invalid EXPOSE port "80/tcpx": unknown protocol "tcpx"`,
+	}, result.Warnings))
+}
+
+func TestParseWithOptionsEmptyContinuationLineError(t *testing.T) {
+	dockerfile := `
+FROM alpine:3.6
+RUN something \
+
+    following
+`
+	_, err := ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{EmptyContinuationLineError: true})
+	assert.Check(t, is.ErrorContains(err, "empty continuation line found in"))
+
+	var perr *ParseError
+	assert.Assert(t, errors.As(err, &perr))
+	assert.Check(t, is.Equal(4, perr.Line))
+
+	result, err := ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{})
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(result.Warnings, 2))
+
+	_, err = ParseWithOptions(strings.NewReader("FROM alpine\nRUN foo \\\n\n bar\n"), ParseOptions{EmptyContinuationLineError: true})
+	assert.Check(t, is.Error(err, "Dockerfile parse error line 3: empty continuation line found in:\n    RUN foo  bar"))
+
+	result, err = Parse(strings.NewReader("FROM alpine\nRUN foo \\\n\n bar\n"))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("foo  bar", result.AST.Children[1].Next.Value))
+	assert.Check(t, is.Len(result.Warnings, 2))
+}
+
+func TestParseWithOptionsMaxLineSize(t *testing.T) {
+	const maxLineSize = 64
+	opts := ParseOptions{MaxLineSize: maxLineSize}
+
+	label := "LABEL test="
+	under := label + strings.Repeat("a", maxLineSize-len(label))
+	result, err := ParseWithOptions(strings.NewReader("FROM image\n"+under+"\n"), opts)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(result.AST.Children, 2))
+
+	result, err = ParseWithOptions(strings.NewReader("FROM image\n"+under), opts)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(result.AST.Children, 2))
+
+	over := under + "a"
+	_, err = ParseWithOptions(strings.NewReader("FROM image\n"+over+"\n"), opts)
+	assert.Check(t, is.Error(err, "dockerfile line greater than max allowed size of 64"))
+
+	_, err = ParseWithOptions(strings.NewReader(over+"\n"), opts)
+	assert.Check(t, is.Error(err, "dockerfile line greater than max allowed size of 64"))
 }
 
 func TestParseReturnsScannerErrors(t *testing.T) {
@@ -173,6 +726,258 @@ func TestParseReturnsScannerErrors(t *testing.T) {
 	assert.Check(t, is.Error(err, "dockerfile line greater than max allowed size of 65535"))
 }
 
+func TestParseNormalize(t *testing.T) {
+	dockerfile := "from alpine\nRUN x\nCopy a b\nonbuild run make\n"
+	result, err := ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{Normalize: true})
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(result.AST.Children, 4))
+
+	var values []string
+	for _, child := range result.AST.Children {
+		values = append(values, child.Value)
+	}
+	assert.Check(t, is.DeepEqual([]string{"FROM", "RUN", "COPY", "ONBUILD"}, values))
+	assert.Check(t, is.Equal("alpine", result.AST.Children[0].Next.Value))
+	assert.Check(t, is.Equal("Copy a b", result.AST.Children[2].Original))
+	assert.Check(t, is.Equal("RUN", result.AST.Children[3].Next.Children[0].Value))
+	assert.Check(t, is.DeepEqual([]string{
+		"[WARNING]: line 3: instruction Copy should be either all uppercase or all lowercase",
+	}, result.Warnings))
+
+	buf := &bytes.Buffer{}
+	assert.NilError(t, result.Unparse(buf))
+	assert.Check(t, is.Equal("FROM alpine\nRUN x\nCOPY a b\nONBUILD RUN make\n", buf.String()))
+
+	result, err = Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("copy", result.AST.Children[2].Value))
+	assert.Check(t, is.Len(result.Warnings, 0))
+}
+
+func TestParseCanonicalWhitespace(t *testing.T) {
+	dockerfile := "FROM alpine\nRUN   apt-get    update  \nRUN echo \"a   b\" 'c   d'  e\\  f\nCMD [\"echo\",  \"a   b\"]\nRUN make \\\n    install\nONBUILD RUN  make   all\n"
+	result, err := ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{CanonicalWhitespace: true})
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(result.AST.Children, 6))
+
+	assert.Check(t, is.DeepEqual([]string{"run", "apt-get update"}, nodeValues(result.AST.Children[1])))
+	assert.Check(t, is.Equal("RUN   apt-get    update  ", result.AST.Children[1].Original))
+	assert.Check(t, is.DeepEqual([]string{"run", `echo "a   b" 'c   d' e\  f`}, nodeValues(result.AST.Children[2])))
+	assert.Check(t, is.DeepEqual([]string{"cmd", "echo", "a   b"}, nodeValues(result.AST.Children[3])))
+	assert.Check(t, is.DeepEqual([]string{"run", "make install"}, nodeValues(result.AST.Children[4])))
+	assert.Check(t, is.DeepEqual([]string{"run", "make all"}, nodeValues(result.AST.Children[5].Next.Children[0])))
+
+	buf := &bytes.Buffer{}
+	assert.NilError(t, result.Unparse(buf))
+	assert.Check(t, is.Equal("FROM alpine\nRUN apt-get update\nRUN echo \"a   b\" 'c   d' e\\  f\nCMD [\"echo\",\"a   b\"]\nRUN make install\nONBUILD RUN make all\n", buf.String()))
+
+	result, err = Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("apt-get    update", result.AST.Children[1].Next.Value))
+
+	a, err := ParseWithOptions(strings.NewReader("FROM alpine\nRUN  make   all\n"), ParseOptions{CanonicalWhitespace: true})
+	assert.NilError(t, err)
+	b, err := ParseWithOptions(strings.NewReader("FROM alpine\nRUN make all\n"), ParseOptions{CanonicalWhitespace: true})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(a.Hash(), b.Hash()))
+}
+
+func TestParseCustomCommands(t *testing.T) {
+	var included []string
+	opts := ParseOptions{
+		Commands: map[string]InstructionParser{
+			"Include": func(args string, d *Directive) (*Node, map[string]bool, error) {
+				included = append(included, args)
+				return parseStringsWhitespaceDelimited(args, d)
+			},
+		},
+	}
+	dockerfile := "FROM busybox\nINCLUDE other.dockerfile more.dockerfile\nONBUILD include nested.dockerfile\n"
+	result, err := ParseWithOptions(strings.NewReader(dockerfile), opts)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(result.Warnings, 0))
+	assert.Check(t, is.DeepEqual([]string{"other.dockerfile more.dockerfile", "nested.dockerfile"}, included))
+
+	include := result.AST.Children[1]
+	assert.Check(t, is.Equal("include", include.Value))
+	assert.Check(t, is.DeepEqual([]string{"other.dockerfile", "more.dockerfile"}, nodeValues(include.Next)))
+
+	// the handlers are not registered globally
+	result, err = Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{
+		"[WARNING]: line 2: unknown instruction: INCLUDE",
+		"[WARNING]: line 3: unknown instruction in ONBUILD: INCLUDE",
+	}, result.Warnings))
+	assert.Check(t, is.Equal("", result.AST.Children[1].Next.Value))
+
+	opts.Commands["run"] = parseIgnore
+	_, err = ParseWithOptions(strings.NewReader(dockerfile), opts)
+	assert.Check(t, is.Error(err, "cannot override built-in instruction RUN"))
+}
+
+func TestDumpIndent(t *testing.T) {
+	dockerfile := `FROM golang AS build
+RUN --mount=type=cache,target=/root/.cache \
+    go build -o /app .
+ONBUILD RUN make
+ONBUILD ONBUILD COPY --from=build /app /app
+CMD ["/app", "serve"]
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+
+	expected := `FROM [1-1]: "golang" "AS" "build"
+RUN [2-3] ["--mount=type=cache,target=/root/.cache"]: "go build -o /app ."
+ONBUILD [4-4]:
+  RUN [4-4]: "make"
+ONBUILD [5-5]:
+  ONBUILD [5-5]:
+    COPY [5-5] ["--from=build"]: "/app" "/app"
+CMD [6-6]: "/app" "serve"
+`
+	assert.Check(t, is.Equal(expected, result.AST.DumpIndent()))
+}
+
+func TestParseCRLF(t *testing.T) {
+	dockerfile := "FROM busybox\r\nRUN foo \\\r\n bar\r\nCMD [\"echo\", \"a\\rb\"]\r\nRUN baz \\\r\r\n qux\r\n"
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(result.AST.Children, 4))
+
+	run := result.AST.Children[1]
+	assert.Check(t, is.Equal("run", run.Value))
+	assert.Check(t, is.Equal("foo  bar", run.Next.Value))
+	assert.Check(t, is.Equal(2, run.StartLine))
+	assert.Check(t, is.Equal(3, run.EndLine()))
+
+	cmd := result.AST.Children[2]
+	assert.Check(t, is.Equal("a\rb", cmd.Next.Next.Value))
+
+	assert.Check(t, is.Equal("baz  qux", result.AST.Children[3].Next.Value))
+}
+
+func TestParseMultilineJSONArray(t *testing.T) {
+	dockerfile := `FROM busybox
+CMD ["echo", "]",
+  "b"]
+RUN ["sh", "-c", "echo \"[\"",
+  "x"]
+RUN echo "[not json"
+ENTRYPOINT ["a", "[[", "]"]
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(result.AST.Children, 5))
+
+	cmd := result.AST.Children[1]
+	assert.Check(t, is.Equal("cmd", cmd.Value))
+	assert.Check(t, is.Equal(3, cmd.EndLine()))
+	assert.Check(t, is.DeepEqual([]string{"echo", "]", "b"}, nodeValues(cmd.Next)))
+
+	run := result.AST.Children[2]
+	assert.Check(t, is.Equal(5, run.EndLine()))
+	assert.Check(t, is.DeepEqual([]string{"sh", "-c", `echo "["`, "x"}, nodeValues(run.Next)))
+
+	assert.Check(t, is.Equal(`echo "[not json"`, result.AST.Children[3].Next.Value))
+	assert.Check(t, is.DeepEqual([]string{"a", "[[", "]"}, nodeValues(result.AST.Children[4].Next)))
+}
+
+func TestParseUnterminatedJSONArray(t *testing.T) {
+	dockerfile := "FROM busybox\nCMD [\"a\",\n  \"b\"\n\n"
+	_, err := Parse(strings.NewReader(dockerfile))
+	assert.Check(t, is.Error(err, "Dockerfile parse error line 2: unterminated JSON array started at line 2"))
+
+	var perr *ParseError
+	assert.Assert(t, errors.As(err, &perr))
+	assert.Check(t, is.Equal(2, perr.Line))
+
+	_, err = Parse(strings.NewReader("FROM busybox\nRUN echo hello\nCMD [\n  \"a\",\n"))
+	assert.Check(t, is.Error(err, "Dockerfile parse error line 3: unterminated JSON array started at line 3"))
+
+	_, errs := ParseWithOptions(strings.NewReader("FROM busybox\nCMD [\"a\",\n  \"b\"\n"), ParseOptions{CollectErrors: true})
+	assert.Check(t, is.ErrorContains(errs, "unterminated JSON array started at line 2"))
+
+	// a single line is parsed in shell form, as are shell form arguments
+	// holding an unclosed [
+	result, err := Parse(strings.NewReader("FROM busybox\nCMD [\"a\",\n"))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(`["a",`, result.AST.Children[1].Next.Value))
+	result, err = Parse(strings.NewReader("FROM busybox\nRUN echo [\n"))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("echo [", result.AST.Children[1].Next.Value))
+}
+
+func TestParseWindowsPaths(t *testing.T) {
+	// backslashes are only special at the end of a line, where they
+	// continue the instruction even when meant as a path separator
+	result, err := Parse(strings.NewReader("FROM servercore\nCOPY C:\\src\\app.exe \\dest\nWORKDIR C:\\app\\\nRUN dir\n"))
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(result.AST.Children, 3))
+	assert.Check(t, is.DeepEqual([]string{"copy", `C:\src\app.exe`, `\dest`}, nodeValues(result.AST.Children[1])))
+	assert.Check(t, is.DeepEqual([]string{"workdir", `C:\appRUN dir`}, nodeValues(result.AST.Children[2])))
+
+	result, err = Parse(strings.NewReader("# escape=`\nFROM servercore\nWORKDIR C:\\app\\\nRUN dir C:\\app\\ `\n  && echo done\n"))
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(result.AST.Children, 3))
+	assert.Check(t, is.DeepEqual([]string{"workdir", `C:\app\`}, nodeValues(result.AST.Children[1])))
+	assert.Check(t, is.DeepEqual([]string{"run", `dir C:\app\   && echo done`}, nodeValues(result.AST.Children[2])))
+}
+
+func TestParseMultilineJSONArrayWithEscape(t *testing.T) {
+	dockerfile := `FROM busybox
+CMD ["a", \
+  "b",
+  "c", \
+
+  "d"]
+RUN ["sh", \
+  # a comment
+  "-c",
+  "echo \\"]
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(result.AST.Children, 3))
+
+	cmd := result.AST.Children[1]
+	assert.Check(t, cmd.IsJSON())
+	assert.Check(t, is.Equal(6, cmd.EndLine()))
+	assert.Check(t, is.DeepEqual([]string{"a", "b", "c", "d"}, nodeValues(cmd.Next)))
+
+	run := result.AST.Children[2]
+	assert.Check(t, run.IsJSON())
+	assert.Check(t, is.Equal(10, run.EndLine()))
+	assert.Check(t, is.DeepEqual([]string{"sh", "-c", `echo \`}, nodeValues(run.Next)))
+
+	result, err = Parse(strings.NewReader("# escape=`\nFROM windows\nCMD [\"a\", `\n  \"C:\\\\b\",\n  \"c\"]\n"))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{"a", `C:\b`, "c"}, nodeValues(result.AST.Children[1].Next)))
+}
+
+func TestHasUnclosedJSONArray(t *testing.T) {
+	for line, expected := range map[string]bool{
+		`CMD ["a",`:        true,
+		`CMD ["a"]`:        false,
+		`CMD ["]",`:        true,
+		`CMD ["[",`:        true,
+		`CMD ["\"[", "b"]`: false,
+		`CMD ["a\\", "[",`: true,
+		`RUN echo "[x"`:    false,
+		`RUN echo ] [`:     true,
+	} {
+		assert.Check(t, is.Equal(expected, hasUnclosedJSONArray(line)), line)
+	}
+}
+
+func nodeValues(node *Node) []string {
+	var values []string
+	for n := node; n != nil; n = n.Next {
+		values = append(values, n.Value)
+	}
+	return values
+}
+
 func TestJSONArraysOfStrings(t *testing.T) {
 	var invalidJSONArraysOfStrings = []string{
 		`["a",42,"b"]`,
@@ -185,14 +990,14 @@ func TestJSONArraysOfStrings(t *testing.T) {
 		`["a",null,"b"]`,
 	}
 	var validJSONArraysOfStrings = map[string][]string{
-		`[]`:           {},
-		`[""]`:         {""},
-		`["a"]`:        {"a"},
-		`["a","b"]`:    {"a", "b"},
-		`[ "a", "b" ]`: {"a", "b"},
-		`[	"a",	"b"	]`: {"a", "b"},
-		`	[	"a",	"b"	]	`: {"a", "b"},
-		"[\"a\", \n \"b\"]":                                      {"a", "b"},
+		`[]`:                {},
+		`[""]`:              {""},
+		`["a"]`:             {"a"},
+		`["a","b"]`:         {"a", "b"},
+		`[ "a", "b" ]`:      {"a", "b"},
+		`[	"a",	"b"	]`:      {"a", "b"},
+		`	[	"a",	"b"	]	`:    {"a", "b"},
+		"[\"a\", \n \"b\"]": {"a", "b"},
 		`["abc 123","♥", "☃", "\" \\ \/ \b \f \n \r \t \u0000"]`: {"abc 123", "♥", "☃", "\" \\ / \b \f \n \r \t \u0000"},
 	}
 
@@ -226,3 +1031,174 @@ func TestJSONArraysOfStrings(t *testing.T) {
 		}
 	}
 }
+
+func TestParseWarnsMisplacedDirective(t *testing.T) {
+	dockerfile := "FROM busybox\n# escape=`\nRUN echo hello \\\n  world\n#syntax=docker/dockerfile:1\n# foo=bar\n"
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(DefaultEscapeToken, result.EscapeToken))
+	assert.Check(t, is.Equal("", result.Syntax))
+	assert.Check(t, is.DeepEqual([]string{
+		"[WARNING]: line 2: the escape parser directive is ignored, parser directives must precede any instruction",
+		"[WARNING]: line 5: the syntax parser directive is ignored, parser directives must precede any instruction",
+	}, result.Warnings))
+}
+
+func TestParseKeepsHashInArguments(t *testing.T) {
+	dockerfile := `FROM busybox
+RUN echo "# hi"
+RUN echo '#notacomment' && \
+    # a comment line
+    echo foo # shell comment
+COPY a#b /c#d
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{`echo "# hi"`}, nodeValues(result.AST.Children[1].Next)))
+	assert.Check(t, is.DeepEqual([]string{`echo '#notacomment' &&     echo foo # shell comment`}, nodeValues(result.AST.Children[2].Next)))
+	assert.Check(t, is.DeepEqual([]string{"a#b", "/c#d"}, nodeValues(result.AST.Children[3].Next)))
+}
+
+func TestParseMaxLayerInstructions(t *testing.T) {
+	dockerfile := `FROM busybox AS build
+RUN one
+COPY a /a
+RUN three
+ENV not=counted
+FROM busybox
+RUN one
+RUN two
+COPY --from=build /a /a
+RUN four
+`
+	result, err := ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{MaxLayerInstructions: 3})
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{
+		"[WARNING]: line 10: stage starting on line 6 has more than 3 RUN, COPY and ADD instructions",
+	}, result.Warnings))
+
+	_, err = ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{MaxLayerInstructions: 3, Strict: true})
+	assert.Check(t, is.Error(err, "Dockerfile parse error line 10: stage starting on line 6 has more than 3 RUN, COPY and ADD instructions"))
+
+	result, err = Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(result.Warnings, 0))
+}
+
+func TestParseFirstInstruction(t *testing.T) {
+	result, err := Parse(strings.NewReader("# syntax=docker/dockerfile:1\n# a comment\nARG VERSION=1\nARG BASE\nFROM busybox:$VERSION\nRUN true\n"))
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(result.Warnings, 0))
+
+	dockerfile := "# a comment\n\nRUN echo hello\nRUN echo world\nFROM busybox\n"
+	result, err = Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{
+		"[WARNING]: line 3: RUN can't precede the first FROM, only ARG instructions can",
+	}, result.Warnings))
+
+	_, err = ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{Strict: true})
+	assert.Check(t, is.Error(err, "Dockerfile parse error line 3: RUN can't precede the first FROM, only ARG instructions can"))
+}
+
+func TestParseDefaultEscapeToken(t *testing.T) {
+	dockerfile := "FROM windowsservercore\nRUN dir c:\\ `\n  && echo done\n"
+	result, err := ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{DefaultEscapeToken: '`'})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal('`', result.EscapeToken))
+	assert.Check(t, is.Len(result.AST.Children, 2))
+	assert.Check(t, is.DeepEqual([]string{`dir c:\   && echo done`}, nodeValues(result.AST.Children[1].Next)))
+
+	// the escape directive takes precedence
+	result, err = ParseWithOptions(strings.NewReader("# escape=\\\n"+dockerfile), ParseOptions{DefaultEscapeToken: '`'})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal('\\', result.EscapeToken))
+
+	_, err = ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{DefaultEscapeToken: '$'})
+	assert.Check(t, is.Error(err, "invalid ESCAPE '$'. Must be ` or \\"))
+}
+
+func TestSetEscapeTokenAfterProcessingComplete(t *testing.T) {
+	d := NewDefaultDirective()
+	assert.NilError(t, d.setEscapeToken("`"))
+	assert.Check(t, is.Equal('`', d.EscapeToken()))
+
+	_, err := processLine(d, []byte("FROM busybox"), true)
+	assert.NilError(t, err)
+	err = d.setEscapeToken("\\")
+	assert.Check(t, is.Error(err, "the escape token can't be changed after the parser directives"))
+	assert.Check(t, is.Equal('`', d.EscapeToken()))
+}
+
+// cancelingReader cancels a context once it has been read from
+type cancelingReader struct {
+	io.Reader
+	cancel func()
+}
+
+func (r *cancelingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.cancel()
+	return n, err
+}
+
+func TestParseContext(t *testing.T) {
+	dockerfile := "FROM busybox\n" + strings.Repeat("RUN echo hello \\\n  world\n", 10000)
+
+	result, err := ParseContext(context.Background(), strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(result.AST.Children, 10001))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err = ParseContext(ctx, &cancelingReader{Reader: strings.NewReader(dockerfile), cancel: cancel})
+	assert.Check(t, errors.Is(err, context.Canceled), "%v", err)
+
+	ctx, cancel = context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	_, err = ParseContext(ctx, strings.NewReader(dockerfile))
+	assert.Check(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+}
+
+func TestReparseLine(t *testing.T) {
+	d := NewDefaultDirective()
+	node, err := ReparseLine(`COPY --from=build --chown=app:app /src /dst`, d)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("copy", node.Value))
+	assert.Check(t, is.DeepEqual([]string{"--from=build", "--chown=app:app"}, node.Flags))
+	assert.Check(t, is.DeepEqual([]string{"/src", "/dst"}, nodeValues(node.Next)))
+
+	node, err = ReparseLine(`CMD ["echo", "hello world"]`, d)
+	assert.NilError(t, err)
+	assert.Check(t, node.IsJSON())
+	assert.Check(t, is.DeepEqual([]string{"echo", "hello world"}, nodeValues(node.Next)))
+
+	_, err = ReparseLine("  \n", d)
+	assert.Check(t, is.Error(err, "no instruction to parse"))
+}
+
+func TestReparseLineWithDirective(t *testing.T) {
+	result, err := Parse(strings.NewReader("# escape=`\nFROM windowsservercore\nRUN dir c:\\\n"))
+	assert.NilError(t, err)
+
+	node, err := ReparseLine("RUN dir c:\\ `\n  # a comment\n  && echo done\nRUN ignored", result.Directive())
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{`dir c:\   && echo done`}, nodeValues(node.Next)))
+
+	node, err = ReparseLine("RUN dir c:\\", result.Directive())
+	assert.NilError(t, err)
+	assert.Check(t, result.AST.Children[1].Equal(node))
+}
+
+func TestParseCounts(t *testing.T) {
+	dockerfile := "# a comment\nFROM busybox\n\nRUN echo one \\\n  two \\\n  three\n"
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(6, result.LineCount))
+	assert.Check(t, is.Equal(2, result.InstructionCount()))
+
+	result, err = ParseWithOptions(strings.NewReader(dockerfile+"RUN <<EOF\necho hi\nEOF\n"), ParseOptions{Lossless: true})
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(9, result.LineCount))
+	assert.Check(t, is.Equal(3, result.InstructionCount()))
+}
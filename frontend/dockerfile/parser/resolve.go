@@ -0,0 +1,237 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/command"
+)
+
+// expandedCommands are the instructions whose arguments the builder expands
+// variables in. The others, e.g. RUN, leave it to the shell at run time.
+var expandedCommands = map[string]struct{}{
+	command.Add:        {},
+	command.Arg:        {},
+	command.Copy:       {},
+	command.Env:        {},
+	command.Expose:     {},
+	command.From:       {},
+	command.Label:      {},
+	command.StopSignal: {},
+	command.User:       {},
+	command.Volume:     {},
+	command.Workdir:    {},
+}
+
+// ResolveArgs returns a copy of the Result whose AST has the references to
+// build arguments, $VAR and ${VAR}, replaced by their values, e.g. to show
+// `FROM alpine:$TAG` as it will be built. The value of a build argument is
+// its value in extra, or else its default. Global ARGs are only visible in
+// FROM, and in a stage once they are redeclared with ARG. Variables set by
+// ENV in a stage are replaced too, as they take precedence over ARGs.
+//
+// Like the builder, ResolveArgs replaces references in the arguments of
+// ADD, ARG, COPY, ENV, EXPOSE, FROM, LABEL, STOPSIGNAL, USER, VOLUME and
+// WORKDIR, and in the flags of all instructions, but not in single quotes or
+// after the escape token. The forms ${VAR:-word} and ${VAR:+word} are
+// supported, and like for the builder a variable without a known value is
+// unset there, so ${VAR:-word} gives word. Other references to variables
+// without a known value are left as they are and reported as
+// UndefinedVariable warnings in the copy. Original keeps the instructions as
+// written. The copy shares no data with r.
+func (r *Result) ResolveArgs(extra map[string]string) *Result {
+	resolved := *r
+	resolved.AST = r.AST.Clone()
+	resolved.StructuredWarnings = append([]Warning(nil), r.StructuredWarnings...)
+	resolved.TrailingComments = cloneStrings(r.TrailingComments)
+	if r.Directives != nil {
+		resolved.Directives = make(map[string]string, len(r.Directives))
+		for k, v := range r.Directives {
+			resolved.Directives[k] = v
+		}
+	}
+
+	escapeToken := r.EscapeToken
+	if escapeToken == 0 {
+		escapeToken = DefaultEscapeToken
+	}
+	global := map[string]string{}
+	var stage map[string]string // nil before the first FROM
+	var env map[string]bool     // the variables of the stage set by ENV
+	for _, child := range resolved.AST.Children {
+		cmd := strings.ToLower(child.Value)
+		vars := stage
+		if cmd == command.From || stage == nil {
+			vars = global
+		}
+
+		var undefined []string
+		expand := func(s string) string {
+			s, names := expandVars(s, escapeToken, vars)
+			undefined = append(undefined, names...)
+			return s
+		}
+		for i, flag := range child.Flags {
+			child.Flags[i] = expand(flag)
+		}
+		if _, ok := expandedCommands[cmd]; ok {
+			expandArgs(child, expand)
+		}
+
+		switch cmd {
+		case command.From:
+			stage, env = map[string]string{}, map[string]bool{}
+		case command.Arg:
+			for n := child.Next; n != nil; n = n.Next {
+				name, def, hasDefault := n.ArgDefault(escapeToken)
+				value, ok := extra[name]
+				if hasDefault && !ok {
+					value, ok = def, true
+				}
+				if !ok && stage != nil {
+					value, ok = global[name]
+				}
+				if env[name] {
+					continue
+				}
+				if ok {
+					vars[name] = value
+				} else {
+					delete(vars, name)
+				}
+			}
+		case command.Env:
+			if stage == nil {
+				break
+			}
+			for n := child.Next; n != nil && n.Next != nil; n = n.Next.Next {
+				stage[n.Value] = unquoteWord(n.Next.Value, escapeToken)
+				env[n.Value] = true
+			}
+		}
+
+		reported := map[string]bool{}
+		for _, name := range undefined {
+			if reported[name] {
+				continue
+			}
+			reported[name] = true
+			resolved.StructuredWarnings = append(resolved.StructuredWarnings, Warning{
+				RuleID:   RuleUndefinedVariable,
+				Message:  fmt.Sprintf("variable %s has no known value and is left as is", name),
+				Line:     child.StartLine,
+				Severity: SeverityWarning,
+			})
+		}
+	}
+	resolved.Warnings = warningStrings(resolved.StructuredWarnings)
+	return &resolved
+}
+
+// expandArgs replaces the references in the arguments of the instruction
+func expandArgs(node *Node, expand func(string) string) {
+	for n := node.Next; n != nil; n = n.Next {
+		n.Value = expand(n.Value)
+	}
+}
+
+// expandVars replaces the references to the variables in s by their values.
+// It returns the names of the variables referenced that have no value, whose
+// references are kept.
+func expandVars(s string, escapeToken rune, vars map[string]string) (string, []string) {
+	var b strings.Builder
+	var undefined []string
+	var quote rune
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		ch := runes[i]
+		switch {
+		case ch == escapeToken && i+1 < len(runes):
+			b.WriteRune(ch)
+			b.WriteRune(runes[i+1])
+			i++
+			continue
+		case ch == '\'' && quote != '"', ch == '"' && quote != '\'':
+			if quote == ch {
+				quote = 0
+			} else {
+				quote = ch
+			}
+		case ch == '$' && quote != '\'':
+			end, value, names, ok := expandVar(runes, i, escapeToken, vars)
+			if end > i {
+				if ok {
+					b.WriteString(value)
+				} else {
+					b.WriteString(string(runes[i:end]))
+				}
+				undefined = append(undefined, names...)
+				i = end - 1
+				continue
+			}
+		}
+		b.WriteRune(ch)
+	}
+	return b.String(), undefined
+}
+
+// expandVar expands the reference starting with the $ at runes[i]. It returns
+// the end of the reference, which is i if there is none, its expansion and
+// the names of the variables without a value it references, and whether it
+// could be expanded.
+func expandVar(runes []rune, i int, escapeToken rune, vars map[string]string) (int, string, []string, bool) {
+	j := i + 1
+	if j < len(runes) && runes[j] != '{' {
+		for j < len(runes) && isNameChar(runes[j], j == i+1) {
+			j++
+		}
+		if j == i+1 {
+			return i, "", nil, false
+		}
+		name := string(runes[i+1 : j])
+		if value, ok := vars[name]; ok {
+			return j, value, nil, true
+		}
+		return j, "", []string{name}, false
+	}
+
+	end := j
+	for end < len(runes) && runes[end] != '}' {
+		end++
+	}
+	if end == len(runes) {
+		return i, "", nil, false
+	}
+	ref := string(runes[j+1 : end])
+	name := ref
+	var modifier, word string
+	if k := strings.Index(ref, ":"); k >= 0 && k+1 < len(ref) {
+		name, modifier, word = ref[:k], ref[k:k+2], ref[k+2:]
+	}
+	value, ok := vars[name]
+	var undefined []string
+	switch modifier {
+	case "":
+		if !ok {
+			return end + 1, "", []string{name}, false
+		}
+	case ":-":
+		if value == "" {
+			value, undefined = expandVars(word, escapeToken, vars)
+		}
+	case ":+":
+		if value != "" {
+			value, undefined = expandVars(word, escapeToken, vars)
+		}
+	default:
+		if !ok {
+			return end + 1, "", []string{name}, false
+		}
+		return end + 1, "", nil, false
+	}
+	return end + 1, value, undefined, true
+}
+
+func isNameChar(ch rune, first bool) bool {
+	return ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || !first && ch >= '0' && ch <= '9'
+}
@@ -0,0 +1,84 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestResolveArgs(t *testing.T) {
+	dockerfile := `ARG TAG=3.18
+ARG BASE=alpine
+ARG VERSION
+FROM $BASE:$TAG AS build
+RUN echo $TAG
+ARG TAG
+ARG DIR=/src/${TAG}
+WORKDIR $DIR
+COPY --chown=${USER:-root} '$DIR' \$DIR $DIR/
+ENV TAG=edge
+LABEL version=${VERSION:+v$VERSION} tag="$TAG"
+FROM alpine:$UNKNOWN
+WORKDIR $DIR
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+
+	resolved := result.ResolveArgs(nil)
+	assert.Check(t, is.DeepEqual([]string{"alpine:3.18", "AS", "build"}, nodeValues(resolved.AST.Children[3].Next)))
+	assert.Check(t, is.Equal("FROM $BASE:$TAG AS build", resolved.AST.Children[3].Original))
+	assert.Check(t, is.Equal("echo $TAG", resolved.AST.Children[4].Next.Value))
+	assert.Check(t, is.DeepEqual([]string{"DIR=/src/3.18"}, nodeValues(resolved.AST.Children[6].Next)))
+	assert.Check(t, is.Equal("/src/3.18", resolved.AST.Children[7].Next.Value))
+	copyNode := resolved.AST.Children[8]
+	assert.Check(t, is.DeepEqual([]string{"--chown=root"}, copyNode.Flags))
+	assert.Check(t, is.DeepEqual([]string{"'$DIR'", `\$DIR`, "/src/3.18/"}, nodeValues(copyNode.Next)))
+	assert.Check(t, is.DeepEqual([]string{"version", "", "tag", `"edge"`}, nodeValues(resolved.AST.Children[10].Next)))
+	assert.Check(t, is.Equal("alpine:$UNKNOWN", resolved.AST.Children[11].Next.Value))
+	assert.Check(t, is.Equal("$DIR", resolved.AST.Children[12].Next.Value))
+
+	assert.Check(t, is.DeepEqual([]Warning{
+		{RuleID: RuleUndefinedVariable, Message: "variable UNKNOWN has no known value and is left as is", Line: 12, Severity: SeverityWarning},
+		{RuleID: RuleUndefinedVariable, Message: "variable DIR has no known value and is left as is", Line: 13, Severity: SeverityWarning},
+	}, resolved.StructuredWarnings))
+	assert.Check(t, is.Len(resolved.Warnings, 2))
+
+	// the original is unchanged
+	assert.Check(t, is.Equal("$BASE:$TAG", result.AST.Children[3].Next.Value))
+	assert.Check(t, is.Len(result.Warnings, 0))
+
+	resolved = result.ResolveArgs(map[string]string{"TAG": "3.19", "VERSION": "1.0"})
+	assert.Check(t, is.Equal("alpine:3.19", resolved.AST.Children[3].Next.Value))
+	assert.Check(t, is.Equal("/src/3.19", resolved.AST.Children[7].Next.Value))
+	// VERSION isn't redeclared in the stage
+	assert.Check(t, is.Equal("", resolved.AST.Children[10].Next.Next.Value))
+
+	result, err = Parse(strings.NewReader("ARG VERSION\nFROM alpine\nARG VERSION\nLABEL version=${VERSION:+v$VERSION}\n"))
+	assert.NilError(t, err)
+	resolved = result.ResolveArgs(map[string]string{"VERSION": "1.0"})
+	assert.Check(t, is.DeepEqual([]string{"version", "v1.0"}, nodeValues(resolved.AST.Children[3].Next)))
+	assert.Check(t, is.Len(resolved.Warnings, 0))
+
+	result, err = Parse(strings.NewReader("FROM alpine\nWORKDIR ${DIR:-/src/$SUB}\nUSER ${NAME:-root}\n"))
+	assert.NilError(t, err)
+	resolved = result.ResolveArgs(nil)
+	assert.Check(t, is.Equal("/src/$SUB", resolved.AST.Children[1].Next.Value))
+	assert.Check(t, is.Equal("root", resolved.AST.Children[2].Next.Value))
+	assert.Check(t, is.DeepEqual([]Warning{
+		{RuleID: RuleUndefinedVariable, Message: "variable SUB has no known value and is left as is", Line: 2, Severity: SeverityWarning},
+	}, resolved.StructuredWarnings))
+}
+
+func TestResolveArgsCopiesResult(t *testing.T) {
+	result, err := ParseWithOptions(strings.NewReader("# syntax=docker/dockerfile:1\nFROM alpine\n# trailing\n"), ParseOptions{PreserveComments: true})
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(result.TrailingComments, 1))
+
+	resolved := result.ResolveArgs(nil)
+	resolved.Directives["syntax"] = "changed"
+	resolved.TrailingComments[0] = "# changed"
+	assert.Check(t, is.Equal("docker/dockerfile:1", result.Directives["syntax"]))
+	assert.Check(t, is.Equal("# trailing", result.TrailingComments[0]))
+}
@@ -2,9 +2,16 @@ package parser
 
 import (
 	"strings"
-	"unicode"
 )
 
+// SplitCommand splits a single logical Dockerfile line into the lowercased
+// command, the builder flags (e.g. `--from=build`) and the remaining
+// arguments, without parsing the arguments any further. The line must not
+// contain line continuations or comments.
+func SplitCommand(line string) (cmd string, flags []string, args string, err error) {
+	return splitCommand(line)
+}
+
 // splitCommand takes a single line of text and parses out the cmd and args,
 // which are used for dispatching to more exact parsing functions.
 func splitCommand(line string) (string, []string, string, error) {
@@ -12,7 +19,7 @@ func splitCommand(line string) (string, []string, string, error) {
 	var flags []string
 
 	// Make sure we get the same results irrespective of leading/trailing spaces
-	cmdline := tokenWhitespace.Split(strings.TrimSpace(line), 2)
+	cmdline := tokenWhitespace.Split(strings.Trim(line, whitespace), 2)
 	cmd := strings.ToLower(cmdline[0])
 
 	if len(cmdline) == 2 {
@@ -23,7 +30,7 @@ func splitCommand(line string) (string, []string, string, error) {
 		}
 	}
 
-	return cmd, flags, strings.TrimSpace(args), nil
+	return cmd, flags, strings.Trim(args, whitespace), nil
 }
 
 func extractBuilderFlags(line string) (string, []string, error) {
@@ -51,7 +58,7 @@ func extractBuilderFlags(line string) (string, []string, error) {
 			if pos == len(line) { // end of input
 				break
 			}
-			if unicode.IsSpace(ch) { // skip spaces
+			if isWhitespace(ch) { // skip spaces
 				continue
 			}
 
@@ -69,7 +76,7 @@ func extractBuilderFlags(line string) (string, []string, error) {
 			break
 		}
 		if phase == inWord {
-			if unicode.IsSpace(ch) {
+			if isWhitespace(ch) {
 				phase = inSpaces
 				if word == "--" {
 					return line[pos:], words, nil
@@ -94,7 +101,7 @@ func extractBuilderFlags(line string) (string, []string, error) {
 				pos++
 				ch = rune(line[pos])
 			}
-			word += string(ch)
+			word += line[pos : pos+1] // bytes of multi-byte characters are copied one by one
 			continue
 		}
 		if phase == inQuote {
@@ -110,7 +117,7 @@ func extractBuilderFlags(line string) (string, []string, error) {
 				pos++
 				ch = rune(line[pos])
 			}
-			word += string(ch)
+			word += line[pos : pos+1]
 		}
 	}
 
@@ -0,0 +1,22 @@
+package parser
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestSplitCommand(t *testing.T) {
+	cmd, flags, args, err := SplitCommand(`  COPY --from=build --chown="a b" /app /usr/bin/  `)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("copy", cmd))
+	assert.Check(t, is.DeepEqual([]string{"--from=build", "--chown=a b"}, flags))
+	assert.Check(t, is.Equal("/app /usr/bin/", args))
+
+	cmd, flags, args, err = SplitCommand("HEALTHCHECK")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("healthcheck", cmd))
+	assert.Check(t, is.Len(flags, 0))
+	assert.Check(t, is.Equal("", args))
+}
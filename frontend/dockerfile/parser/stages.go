@@ -0,0 +1,198 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/command"
+)
+
+// Stage is a build stage of a Dockerfile, starting at a FROM instruction
+type Stage struct {
+	BaseName  string  // the image or stage the stage is based on
+	BaseIndex int     // index of the stage BaseName refers to, -1 for images
+	Name      string  // the lowercased name given with AS, if any
+	Line      int     // the line of the FROM instruction
+	From      *Node   // the FROM instruction
+	Commands  []*Node // the instructions of the stage following FROM
+}
+
+// Stages splits the instructions into build stages. Instructions preceding
+// the first FROM, i.e. global ARGs, don't belong to any stage.
+func (r *Result) Stages() []Stage {
+	var stages []Stage
+	for _, child := range r.AST.Children {
+		if child.Value == BlankLineNode || child.Value == CommentNode {
+			continue
+		}
+		if !strings.EqualFold(child.Value, command.From) {
+			if len(stages) > 0 {
+				s := &stages[len(stages)-1]
+				s.Commands = append(s.Commands, child)
+			}
+			continue
+		}
+
+		s := Stage{BaseIndex: -1, Line: child.StartLine, From: child}
+		var args []string
+		for n := child.Next; n != nil; n = n.Next {
+			args = append(args, n.Value)
+		}
+		if len(args) > 0 {
+			s.BaseName = args[0]
+		}
+		if len(args) == 3 && strings.EqualFold(args[1], "as") {
+			s.Name = strings.ToLower(args[2])
+		}
+		s.BaseIndex = stageByName(stages, s.BaseName)
+		stages = append(stages, s)
+	}
+	return stages
+}
+
+// stageByName returns the index of the stage named name, or -1 if there is
+// no such stage
+func stageByName(stages []Stage, name string) int {
+	name = strings.ToLower(name)
+	for i, s := range stages {
+		if s.Name != "" && s.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Arg is a build argument declared by an ARG instruction
+type Arg struct {
+	Name    string
+	Default *string // the default value, nil if there is none
+	Global  bool    // whether it is declared before the first FROM
+	Line    int     // the line of the ARG instruction
+}
+
+// Args returns every build argument declared by the top-level ARG
+// instructions, in order. Global arguments must be redeclared in a stage to
+// be used there, except in FROM.
+func (r *Result) Args() []Arg {
+	var args []Arg
+	global := true
+	for _, child := range r.AST.Children {
+		switch {
+		case strings.EqualFold(child.Value, command.From):
+			global = false
+		case strings.EqualFold(child.Value, command.Arg):
+			for _, v := range declaredVariables(child, r.EscapeToken) {
+				arg := Arg{Name: v.name, Global: global, Line: child.StartLine}
+				if v.hasValue {
+					value := v.value
+					arg.Default = &value
+				}
+				args = append(args, arg)
+			}
+		}
+	}
+	return args
+}
+
+// variable is a variable declared by an ARG or ENV instruction
+type variable struct {
+	name     string
+	value    string
+	hasValue bool // false for an ARG without a default
+}
+
+// declaredVariables returns the variables declared by an ARG or ENV
+// instruction, in order, and nil for other instructions. The values are
+// unquoted like ArgDefault does.
+func declaredVariables(node *Node, escapeToken rune) []variable {
+	var vars []variable
+	switch strings.ToLower(node.Value) {
+	case command.Arg:
+		for n := node.Next; n != nil; n = n.Next {
+			v := variable{}
+			v.name, v.value, v.hasValue = n.ArgDefault(escapeToken)
+			vars = append(vars, v)
+		}
+	case command.Env:
+		for n := node.Next; n != nil && n.Next != nil; n = n.Next.Next {
+			vars = append(vars, variable{name: n.Value, value: unquoteWord(n.Next.Value, escapeToken), hasValue: true})
+		}
+	}
+	return vars
+}
+
+// StageRefKind is what the source of a StageRef refers to
+type StageRefKind int
+
+// Kinds of stage references
+const (
+	StageRefIndex    StageRefKind = iota // a stage given by its index, e.g. --from=0
+	StageRefName                         // a stage given by its name
+	StageRefImage                        // an image, e.g. --from=nginx:latest
+	StageRefArgument                     // a value referencing a build argument, which can't be resolved
+)
+
+var stageRefKindNames = map[StageRefKind]string{
+	StageRefIndex:    "index",
+	StageRefName:     "name",
+	StageRefImage:    "image",
+	StageRefArgument: "argument",
+}
+
+func (k StageRefKind) String() string {
+	if name, ok := stageRefKindNames[k]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// StageRef is a reference to a stage or an image made by COPY --from or
+// RUN --mount=from
+type StageRef struct {
+	Name  string // the value of the from flag or mount option
+	Kind  StageRefKind
+	Index int   // index of the stage referred to, -1 for images, arguments and indexes out of range
+	Line  int   // the line of the instruction
+	Node  *Node // the instruction
+}
+
+// StageReferences returns every reference to another stage or an image made
+// by the COPY --from flags and the from option of RUN --mount flags, in
+// order. A name is a stage name if any stage has that name, and an image
+// otherwise. Mounts that can't be parsed are skipped.
+func (r *Result) StageReferences() []StageRef {
+	stages := r.Stages()
+	var refs []StageRef
+	add := func(node *Node, from string) {
+		ref := StageRef{Name: from, Kind: StageRefImage, Index: -1, Line: node.StartLine, Node: node}
+		if strings.Contains(from, "$") {
+			ref.Kind = StageRefArgument
+		} else if index, err := strconv.Atoi(from); err == nil {
+			ref.Kind = StageRefIndex
+			if index >= 0 && index < len(stages) {
+				ref.Index = index
+			}
+		} else if index := stageByName(stages, from); index != -1 {
+			ref.Kind, ref.Index = StageRefName, index
+		}
+		refs = append(refs, ref)
+	}
+
+	for _, s := range stages {
+		for _, n := range s.Commands {
+			switch {
+			case strings.EqualFold(n.Value, command.Copy):
+				for _, from := range flagValues(n.Flags, "from") {
+					add(n, from)
+				}
+			case strings.EqualFold(n.Value, command.Run):
+				for _, value := range flagValues(n.Flags, "mount") {
+					if m, err := ParseMount(value); err == nil && m["from"] != "" {
+						add(n, m["from"])
+					}
+				}
+			}
+		}
+	}
+	return refs
+}
@@ -0,0 +1,117 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestStages(t *testing.T) {
+	dockerfile := `ARG GO_VERSION=1.11
+FROM golang:${GO_VERSION} AS Build
+RUN go build -o /app .
+
+FROM build as test
+RUN go test ./...
+
+FROM alpine
+COPY --from=build /app /app
+CMD ["/app"]
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+
+	stages := result.Stages()
+	assert.Assert(t, is.Len(stages, 3))
+
+	assert.Check(t, is.Equal("golang:${GO_VERSION}", stages[0].BaseName))
+	assert.Check(t, is.Equal(-1, stages[0].BaseIndex))
+	assert.Check(t, is.Equal("build", stages[0].Name))
+	assert.Check(t, is.Equal(2, stages[0].Line))
+	assert.Assert(t, is.Len(stages[0].Commands, 1))
+	assert.Check(t, is.Equal("run", stages[0].Commands[0].Value))
+
+	assert.Check(t, is.Equal("build", stages[1].BaseName))
+	assert.Check(t, is.Equal(0, stages[1].BaseIndex))
+	assert.Check(t, is.Equal("test", stages[1].Name))
+	assert.Check(t, is.Equal(5, stages[1].Line))
+
+	assert.Check(t, is.Equal("alpine", stages[2].BaseName))
+	assert.Check(t, is.Equal(-1, stages[2].BaseIndex))
+	assert.Check(t, is.Equal("", stages[2].Name))
+	assert.Check(t, is.Equal(8, stages[2].Line))
+	assert.Assert(t, is.Len(stages[2].Commands, 2))
+	assert.Check(t, is.DeepEqual([]string{"--from=build"}, stages[2].Commands[0].Flags))
+}
+
+func TestArgs(t *testing.T) {
+	dockerfile := `ARG VERSION=1.0
+FROM busybox:$VERSION
+ARG VERSION
+ARG TARGET=release MODE=
+RUN make $TARGET
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	str := func(s string) *string { return &s }
+	assert.Check(t, is.DeepEqual([]Arg{
+		{Name: "VERSION", Default: str("1.0"), Global: true, Line: 1},
+		{Name: "VERSION", Line: 3},
+		{Name: "TARGET", Default: str("release"), Line: 4},
+		{Name: "MODE", Default: str(""), Line: 4},
+	}, result.Args()))
+}
+
+func TestArgsQuotedDefaults(t *testing.T) {
+	dockerfile := `FROM busybox
+ARG A="x y" B='z' C=pre"fix $D"\ post
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	str := func(s string) *string { return &s }
+	assert.Check(t, is.DeepEqual([]Arg{
+		{Name: "A", Default: str("x y"), Line: 2},
+		{Name: "B", Default: str("z"), Line: 2},
+		{Name: "C", Default: str("prefix $D post"), Line: 2},
+	}, result.Args()))
+
+	result, err = Parse(strings.NewReader("# escape=`\nFROM busybox\nARG A=\"C:\\x y\" B=`\"\n"))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]Arg{
+		{Name: "A", Default: str(`C:\x y`), Line: 3},
+		{Name: "B", Default: str(`"`), Line: 3},
+	}, result.Args()))
+}
+
+func TestStageReferences(t *testing.T) {
+	dockerfile := `FROM golang AS build
+RUN make
+FROM alpine
+COPY --from=0 /a /a
+COPY --from=build /b /b
+COPY --from=nginx:latest /c /c
+COPY --from=$BASE /d /d
+COPY --from=5 /e /e
+RUN --mount=type=bind,from=Build,target=/src --mount=type=cache,target=/cache make
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+
+	var refs []StageRef
+	for _, ref := range result.StageReferences() {
+		assert.Check(t, ref.Node != nil)
+		ref.Node = nil
+		refs = append(refs, ref)
+	}
+	assert.Check(t, is.DeepEqual([]StageRef{
+		{Name: "0", Kind: StageRefIndex, Index: 0, Line: 4},
+		{Name: "build", Kind: StageRefName, Index: 0, Line: 5},
+		{Name: "nginx:latest", Kind: StageRefImage, Index: -1, Line: 6},
+		{Name: "$BASE", Kind: StageRefArgument, Index: -1, Line: 7},
+		{Name: "5", Kind: StageRefIndex, Index: -1, Line: 8},
+		{Name: "Build", Kind: StageRefName, Index: 0, Line: 9},
+	}, refs))
+	assert.Check(t, is.Equal("image", StageRefImage.String()))
+}
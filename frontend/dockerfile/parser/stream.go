@@ -0,0 +1,45 @@
+package parser
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// InstructionOrError is delivered by ParseStream for every instruction that
+// was parsed, or for every error that was found.
+type InstructionOrError struct {
+	Node *Node
+	Err  error
+}
+
+// ParseStream parses a Dockerfile in the background and delivers each
+// top-level instruction on the returned channel as soon as it is complete, so
+// that large Dockerfiles don't have to be held in memory as a whole.
+//
+// Errors are delivered inline and, like with ParseOptions.CollectErrors,
+// parsing continues past instructions that fail to parse. Warnings are not
+// reported. The channel is closed when the end of the input is reached or
+// after a fatal error, such as a line that is too long, and must be drained
+// by the caller.
+func ParseStream(r io.Reader) (<-chan InstructionOrError, error) {
+	if r == nil {
+		return nil, errors.New("cannot parse nil reader")
+	}
+	ch := make(chan InstructionOrError)
+	go func() {
+		defer close(ch)
+		emit := func(child *Node) {
+			ch <- InstructionOrError{Node: child}
+		}
+		report := func(perr *ParseError) error {
+			ch <- InstructionOrError{Err: perr}
+			return nil
+		}
+		if _, err := parse(context.Background(), r, ParseOptions{}, emit, report); err != nil {
+			ch <- InstructionOrError{Err: err}
+		}
+	}()
+	return ch, nil
+}
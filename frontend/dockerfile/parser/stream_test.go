@@ -0,0 +1,60 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestParseStream(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("# escape=`\nFROM busybox\n")
+	for i := 1; i < 5000; i++ {
+		fmt.Fprintf(&sb, "RUN echo %d `\n  done\n", i)
+	}
+
+	ch, err := ParseStream(strings.NewReader(sb.String()))
+	assert.NilError(t, err)
+
+	var count int
+	for item := range ch {
+		assert.NilError(t, item.Err)
+		if count > 0 {
+			assert.Check(t, is.Equal(fmt.Sprintf("echo %d   done", count), item.Node.Next.Value))
+			assert.Check(t, is.Equal(2*count+1, item.Node.StartLine))
+			assert.Check(t, is.Equal(2*count+2, item.Node.EndLine()))
+		}
+		count++
+	}
+	assert.Check(t, is.Equal(5000, count))
+}
+
+func TestParseStreamErrors(t *testing.T) {
+	ch, err := ParseStream(strings.NewReader("FROM busybox\nENV foo\nCMD true\nLABEL a=b c\n"))
+	assert.NilError(t, err)
+
+	var items []InstructionOrError
+	for item := range ch {
+		items = append(items, item)
+	}
+	assert.Assert(t, is.Len(items, 4))
+	assert.Check(t, is.Equal("from", items[0].Node.Value))
+	assert.Check(t, is.ErrorContains(items[1].Err, "Dockerfile parse error line 2: ENV"))
+	assert.Check(t, is.Equal("cmd", items[2].Node.Value))
+	assert.Check(t, is.ErrorContains(items[3].Err, "Dockerfile parse error line 4: Syntax error"))
+}
+
+func TestParseStreamNoInstructions(t *testing.T) {
+	ch, err := ParseStream(strings.NewReader("# only a comment\n"))
+	assert.NilError(t, err)
+
+	var items []InstructionOrError
+	for item := range ch {
+		items = append(items, item)
+	}
+	assert.Assert(t, is.Len(items, 1))
+	assert.Check(t, is.Error(items[0].Err, "file with no instructions."))
+}
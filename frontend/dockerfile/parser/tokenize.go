@@ -0,0 +1,320 @@
+package parser
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// TokenKind is the kind of a Token
+type TokenKind int
+
+// Kinds of tokens returned by Tokenize
+const (
+	TokenKeyword      TokenKind = iota // the instruction, e.g. RUN
+	TokenFlag                          // a builder flag, e.g. --mount=type=cache
+	TokenWord                          // an unquoted argument or part of one
+	TokenString                        // a quoted argument, including the quotes
+	TokenOperator                      // = between a key and a value, or JSON array punctuation
+	TokenComment                       // a comment line
+	TokenDirective                     // a parser directive, e.g. # escape=`
+	TokenContinuation                  // the escape token continuing an instruction on the next line
+	TokenHeredoc                       // a line of heredoc content or its terminator
+)
+
+var tokenKindNames = map[TokenKind]string{
+	TokenKeyword:      "keyword",
+	TokenFlag:         "flag",
+	TokenWord:         "word",
+	TokenString:       "string",
+	TokenOperator:     "operator",
+	TokenComment:      "comment",
+	TokenDirective:    "directive",
+	TokenContinuation: "continuation",
+	TokenHeredoc:      "heredoc",
+}
+
+func (k TokenKind) String() string {
+	if name, ok := tokenKindNames[k]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Token is a lexical token of a Dockerfile
+type Token struct {
+	Kind      TokenKind
+	Text      string
+	Line      int // the line of the token, starting at 1
+	StartByte int // offset of the first byte of the token in the input
+	EndByte   int // offset of the byte following the token
+}
+
+// Tokenize splits a Dockerfile into tokens, e.g. for syntax highlighting.
+// Unlike Parse it doesn't interpret the arguments of instructions, so it
+// accepts unknown instructions and arguments that Parse rejects. Parser
+// directives, including the escape token, are honored. Like for Parse, only
+// a # starting a line starts a comment, one in the arguments of an
+// instruction is part of them. The only errors returned are read errors and
+// invalid parser directives.
+func Tokenize(r io.Reader) ([]Token, error) {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	t := &tokenizer{src: src, d: NewDefaultDirective()}
+
+	pos := 0
+	if bytes.HasPrefix(src, utf8bom) {
+		pos = len(utf8bom)
+	}
+	for pos < len(src) {
+		end := bytes.IndexByte(src[pos:], '\n')
+		if end < 0 {
+			end = len(src)
+		} else {
+			end += pos
+		}
+		t.line++
+		if err := t.lexLine(pos, end); err != nil {
+			return nil, err
+		}
+		pos = end + 1
+	}
+	return t.tokens, nil
+}
+
+type tokenizer struct {
+	src    []byte
+	d      *Directive
+	tokens []Token
+	line   int
+
+	continued   bool      // whether the current line continues an instruction
+	expectFlags bool      // whether builder flags may follow
+	json        bool      // whether the arguments are a JSON array
+	logical     string    // the instruction so far, to find its heredocs
+	heredocs    []Heredoc // heredocs whose content follows
+}
+
+func (t *tokenizer) emit(kind TokenKind, start, end int) {
+	t.tokens = append(t.tokens, Token{
+		Kind:      kind,
+		Text:      string(t.src[start:end]),
+		Line:      t.line,
+		StartByte: start,
+		EndByte:   end,
+	})
+}
+
+func (t *tokenizer) lexLine(start, end int) error {
+	if end > start && t.src[end-1] == '\r' {
+		end--
+	}
+	if len(t.heredocs) > 0 {
+		t.lexHeredocLine(start, end)
+		return nil
+	}
+
+	i := t.skipWhitespace(start, end)
+	if i == end {
+		if !t.continued {
+			t.d.processingComplete = true
+		}
+		return nil
+	}
+	if t.src[i] == '#' {
+		kind := TokenComment
+		if !t.continued && !t.d.processingComplete {
+			n := len(t.d.directives)
+			if err := t.d.possibleParserDirective(string(t.src[i:end])); err != nil {
+				return newParseError(t.line, err)
+			}
+			if len(t.d.directives) > n {
+				kind = TokenDirective
+			}
+		}
+		t.emit(kind, i, end)
+		return nil
+	}
+
+	t.d.processingComplete = true
+	if !t.continued {
+		j := i
+		for j < end && !isWhitespace(rune(t.src[j])) {
+			j++
+		}
+		t.emit(TokenKeyword, i, j)
+		t.expectFlags, t.json, t.logical = true, false, ""
+		i = j
+	}
+
+	contEnd := t.lexArgs(i, end)
+	if contEnd >= 0 {
+		t.logical += string(t.src[start:contEnd])
+		t.continued = true
+		return nil
+	}
+	t.logical += string(t.src[start:end])
+	t.continued = false
+	t.heredocs = heredocsFromLine(t.logical, t.d)
+	return nil
+}
+
+// lexArgs splits the arguments on a line of an instruction into tokens. It
+// returns the offset of the escape token if the line is continued, or -1.
+func (t *tokenizer) lexArgs(i, end int) int {
+	for {
+		i = t.skipWhitespace(i, end)
+		if i == end {
+			return -1
+		}
+		if t.isContinuation(i, end) {
+			t.emit(TokenContinuation, i, i+1)
+			return i
+		}
+
+		ch := t.src[i]
+		if t.expectFlags && bytes.HasPrefix(t.src[i:end], []byte("--")) {
+			j := t.scanFlag(i, end)
+			t.emit(TokenFlag, i, j)
+			i = j
+			continue
+		}
+		if t.expectFlags {
+			t.expectFlags = false
+			t.json = ch == '['
+		}
+
+		if t.json {
+			switch ch {
+			case '[', ']', ',':
+				t.emit(TokenOperator, i, i+1)
+				i++
+			case '"':
+				j, _ := t.scanQuoted(i, end, '\\')
+				t.emit(TokenString, i, j)
+				i = j
+			default:
+				j := i
+				for j < end && !isWhitespace(rune(t.src[j])) && !strings.ContainsRune(`[],"`, rune(t.src[j])) {
+					j++
+				}
+				t.emit(TokenWord, i, j)
+				i = j
+			}
+			continue
+		}
+
+		switch {
+		case ch == '=':
+			t.emit(TokenOperator, i, i+1)
+			i++
+		case ch == '"' || ch == '\'':
+			escapeToken := t.d.escapeToken
+			if ch == '\'' {
+				escapeToken = 0 // nothing can be escaped in single quotes
+			}
+			j, terminated := t.scanQuoted(i, end, escapeToken)
+			if !terminated && t.d.IsLineContinued(string(t.src[i:end])) {
+				k := bytes.LastIndexByte(t.src[i:end], byte(t.d.escapeToken)) + i
+				t.emit(TokenString, i, k)
+				t.emit(TokenContinuation, k, k+1)
+				return k
+			}
+			t.emit(TokenString, i, j)
+			i = j
+		default:
+			j := t.scanWord(i, end)
+			t.emit(TokenWord, i, j)
+			i = j
+		}
+	}
+}
+
+func (t *tokenizer) lexHeredocLine(start, end int) {
+	h := t.heredocs[0]
+	text := string(t.src[start:end])
+	if h.Chomp {
+		text = strings.TrimLeft(text, "\t")
+	}
+	if text == h.Name {
+		t.heredocs = t.heredocs[1:]
+	}
+	if end > start {
+		t.emit(TokenHeredoc, start, end)
+	}
+}
+
+func (t *tokenizer) skipWhitespace(i, end int) int {
+	for i < end && isWhitespace(rune(t.src[i])) {
+		i++
+	}
+	return i
+}
+
+// isContinuation reports whether the escape token at i is followed only by
+// whitespace
+func (t *tokenizer) isContinuation(i, end int) bool {
+	return rune(t.src[i]) == t.d.escapeToken && t.skipWhitespace(i+1, end) == end
+}
+
+// scanWord returns the end of the unquoted word at i, which stops at
+// whitespace, quotes and =. The escape token escapes the following
+// character.
+func (t *tokenizer) scanWord(i, end int) int {
+	for i < end {
+		ch := rune(t.src[i])
+		if isWhitespace(ch) || ch == '"' || ch == '\'' || ch == '=' || t.isContinuation(i, end) {
+			break
+		}
+		if ch == t.d.escapeToken && i+1 < end {
+			i++
+		}
+		i++
+	}
+	return i
+}
+
+// scanFlag returns the end of the builder flag at i, which may contain
+// quoted whitespace.
+func (t *tokenizer) scanFlag(i, end int) int {
+	var quote byte
+	for ; i < end; i++ {
+		ch := t.src[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			} else if ch == '\\' && i+1 < end {
+				i++
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '\\' && i+1 < end:
+			i++
+		case isWhitespace(rune(ch)) || t.isContinuation(i, end):
+			return i
+		}
+	}
+	return i
+}
+
+// scanQuoted returns the end of the string starting with the quote at i and
+// whether it is terminated on the line. escapeToken escapes the following
+// character unless it is 0.
+func (t *tokenizer) scanQuoted(i, end int, escapeToken rune) (int, bool) {
+	quote := t.src[i]
+	for i++; i < end; i++ {
+		ch := t.src[i]
+		if escapeToken != 0 && rune(ch) == escapeToken && i+1 < end {
+			i++
+			continue
+		}
+		if ch == quote {
+			return i + 1, true
+		}
+	}
+	return end, false
+}
@@ -0,0 +1,92 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestTokenize(t *testing.T) {
+	dockerfile := "FROM busybox\nRUN --mount=type=cache,target=/root/.cache make # build it\n"
+	tokens, err := Tokenize(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]Token{
+		{Kind: TokenKeyword, Text: "FROM", Line: 1, StartByte: 0, EndByte: 4},
+		{Kind: TokenWord, Text: "busybox", Line: 1, StartByte: 5, EndByte: 12},
+		{Kind: TokenKeyword, Text: "RUN", Line: 2, StartByte: 13, EndByte: 16},
+		{Kind: TokenFlag, Text: "--mount=type=cache,target=/root/.cache", Line: 2, StartByte: 17, EndByte: 55},
+		{Kind: TokenWord, Text: "make", Line: 2, StartByte: 56, EndByte: 60},
+		{Kind: TokenWord, Text: "#", Line: 2, StartByte: 61, EndByte: 62},
+		{Kind: TokenWord, Text: "build", Line: 2, StartByte: 63, EndByte: 68},
+		{Kind: TokenWord, Text: "it", Line: 2, StartByte: 69, EndByte: 71},
+	}, tokens))
+
+	// the parser keeps the # in the arguments as well
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal("make # build it", result.AST.Children[1].Next.Value))
+}
+
+func tokenKinds(tokens []Token) []string {
+	var kinds []string
+	for _, token := range tokens {
+		kinds = append(kinds, token.Kind.String()+" "+token.Text)
+	}
+	return kinds
+}
+
+func TestTokenizeEscapeAndContinuation(t *testing.T) {
+	dockerfile := "# escape=`\n# a comment\nfrom busybox\nENV a=\"b c\" `\n  # skipped\n  d='e'\nCMD [\"echo\", \"`\"]\nFOO bar\n"
+	tokens, err := Tokenize(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{
+		"directive # escape=`",
+		"comment # a comment",
+		"keyword from",
+		"word busybox",
+		"keyword ENV",
+		"word a",
+		"operator =",
+		`string "b c"`,
+		"continuation `",
+		"comment # skipped",
+		"word d",
+		"operator =",
+		"string 'e'",
+		"keyword CMD",
+		"operator [",
+		`string "echo"`,
+		"operator ,",
+		"string \"`\"",
+		"operator ]",
+		"keyword FOO",
+		"word bar",
+	}, tokenKinds(tokens)))
+	assert.Check(t, is.Equal(6, tokens[10].Line))
+}
+
+func TestTokenizeHeredoc(t *testing.T) {
+	dockerfile := "FROM busybox\nRUN <<EOF\necho hello\nEOF\nCOPY <<-EOT /dest\n\tcontent\n\tEOT\n"
+	tokens, err := Tokenize(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{
+		"keyword FROM",
+		"word busybox",
+		"keyword RUN",
+		"word <<EOF",
+		"heredoc echo hello",
+		"heredoc EOF",
+		"keyword COPY",
+		"word <<-EOT",
+		"word /dest",
+		"heredoc \tcontent",
+		"heredoc \tEOT",
+	}, tokenKinds(tokens)))
+}
+
+func TestTokenizeInvalidDirective(t *testing.T) {
+	_, err := Tokenize(strings.NewReader("# escape=x\nFROM busybox\n"))
+	assert.Check(t, is.ErrorContains(err, "line 1: invalid ESCAPE"))
+}
@@ -0,0 +1,292 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/command"
+	"github.com/pkg/errors"
+)
+
+// Unparse writes the AST back out as Dockerfile source. The original
+// formatting is lost, and so are comments unless they were preserved while
+// parsing, but every instruction is emitted in a form that Parse accepts.
+func (r *Result) Unparse(out io.Writer) error {
+	d := NewDefaultDirective()
+	if r.Syntax != "" {
+		if _, err := fmt.Fprintf(out, "# syntax=%s\n", r.Syntax); err != nil {
+			return err
+		}
+	}
+	if r.Check != "" {
+		if _, err := fmt.Fprintf(out, "# check=%s\n", r.Check); err != nil {
+			return err
+		}
+	}
+	if err := writeUnknownDirectives(out, r.Directives); err != nil {
+		return err
+	}
+	if r.EscapeToken != 0 && r.EscapeToken != DefaultEscapeToken {
+		if err := d.setEscapeToken(string(r.EscapeToken)); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(out, "# escape=%c\n", r.EscapeToken); err != nil {
+			return err
+		}
+	}
+	// a blank line ends the parser directives, so that a leading comment
+	// can't be mistaken for one, unless the AST has one already
+	hasDirectives := r.Syntax != "" || r.Check != "" || hasUnknownDirectives(r.Directives) || d.escapeToken != DefaultEscapeToken
+	if (hasDirectives || tokenParserDirective.MatchString(firstComment(r))) && !startsWithBlankLine(r) {
+		if _, err := io.WriteString(out, "\n"); err != nil {
+			return err
+		}
+	}
+
+	for _, child := range r.AST.Children {
+		if err := writeComments(out, child.Comments); err != nil {
+			return err
+		}
+		if child.Value == BlankLineNode || child.Value == CommentNode {
+			if _, err := io.WriteString(out, child.Original+"\n"); err != nil {
+				return err
+			}
+			continue
+		}
+		line, err := unparseInstruction(child, d)
+		if err != nil {
+			return err
+		}
+		if d.IsLineContinued(line) {
+			return errors.Errorf("cannot unparse line %d: instruction ends with escape token %q", child.StartLine, d.escapeToken)
+		}
+		if _, err := io.WriteString(out, line+"\n"); err != nil {
+			return err
+		}
+		for _, h := range child.Heredocs {
+			if _, err := io.WriteString(out, h.Content+h.Name+"\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return writeComments(out, r.TrailingComments)
+}
+
+// writeUnknownDirectives writes the directives collected with
+// ParseOptions.CollectDirectives that the parser doesn't know, sorted by name
+func writeUnknownDirectives(out io.Writer, directives map[string]string) error {
+	var names []string
+	for name := range directives {
+		if _, ok := parserDirectives[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := fmt.Fprintf(out, "# %s=%s\n", name, directives[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasUnknownDirectives(directives map[string]string) bool {
+	for name := range directives {
+		if _, ok := parserDirectives[name]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteTo implements io.WriterTo by writing the Dockerfile source produced
+// by Unparse to w.
+func (r *Result) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	err := r.Unparse(cw)
+	return cw.n, err
+}
+
+// countingWriter counts the bytes written to the underlying writer
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+func startsWithBlankLine(r *Result) bool {
+	return len(r.AST.Children) > 0 && r.AST.Children[0].Value == BlankLineNode
+}
+
+// firstComment returns the comment that would be written before any
+// instruction
+func firstComment(r *Result) string {
+	if len(r.AST.Children) > 0 {
+		first := r.AST.Children[0]
+		if len(first.Comments) > 0 {
+			return first.Comments[0]
+		}
+		if first.Value == CommentNode {
+			return first.Original
+		}
+		return ""
+	}
+	if len(r.TrailingComments) > 0 {
+		return r.TrailingComments[0]
+	}
+	return ""
+}
+
+func writeComments(out io.Writer, comments []string) error {
+	for _, comment := range comments {
+		if _, err := io.WriteString(out, comment+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unparseInstruction renders a single instruction node as one logical line.
+func unparseInstruction(node *Node, d *Directive) (string, error) {
+	if _, ok := dispatch[strings.ToLower(node.Value)]; !ok {
+		// Arguments of unknown instructions are not kept in the AST.
+		return node.Original, nil
+	}
+
+	parts := []string{strings.ToUpper(node.Value)}
+	for _, flag := range node.Flags {
+		parts = append(parts, quoteFlag(flag))
+	}
+
+	args, err := unparseArgs(node, d)
+	if err != nil {
+		return "", err
+	}
+	if args != "" {
+		parts = append(parts, args)
+	}
+	keys := make([]string, 0, len(node.Meta))
+	for k := range node.Meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		parts = append(parts, "# "+k+": "+node.Meta[k])
+	}
+	return strings.Join(parts, " "), nil
+}
+
+func unparseArgs(node *Node, d *Directive) (string, error) {
+	switch strings.ToLower(node.Value) {
+	case command.Onbuild:
+		if node.Next == nil || len(node.Next.Children) == 0 {
+			return "", nil
+		}
+		return unparseInstruction(node.Next.Children[0], d)
+	case command.Env, command.Label:
+		return unparseKeyValues(node, d)
+	case command.Healthcheck:
+		if node.Next == nil {
+			return "", nil
+		}
+		rest, err := unparseList(node.Next.Next, node.Attributes)
+		if err != nil || rest == "" {
+			return node.Next.Value, err
+		}
+		return node.Next.Value + " " + rest, nil
+	}
+	return unparseList(node.Next, node.Attributes)
+}
+
+// unparseKeyValues renders ENV and LABEL pairs. A single pair whose value is
+// not a plain word is emitted in the legacy `KEY name value` form, which
+// keeps the value verbatim.
+func unparseKeyValues(node *Node, d *Directive) (string, error) {
+	var pairs []string
+	for key := node.Next; key != nil; key = key.Next {
+		if key.Next == nil {
+			return "", errors.Errorf("%s on line %d has a key without value", strings.ToUpper(node.Value), node.StartLine)
+		}
+		value := key.Next
+		if key == node.Next && value.Next == nil && value.Value != "" && !strings.Contains(key.Value, "=") {
+			if quoted := quoteWord(value.Value, d); quoted != value.Value {
+				return key.Value + " " + value.Value, nil
+			}
+		}
+		pairs = append(pairs, key.Value+"="+quoteWord(value.Value, d))
+		key = value
+	}
+	return strings.Join(pairs, " "), nil
+}
+
+// unparseList renders a Next chain either as a JSON array or as space
+// separated words, depending on the form the arguments were parsed from.
+func unparseList(node *Node, attrs map[string]bool) (string, error) {
+	var values []string
+	for n := node; n != nil; n = n.Next {
+		values = append(values, n.Value)
+	}
+	if !attrs["json"] {
+		return strings.Join(values, " "), nil
+	}
+
+	if values == nil {
+		values = []string{}
+	}
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(values); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// quoteWord quotes a value if it would not be read back as a single word.
+// Empty values are left bare, as `key=` is read back as an empty value and
+// `key=""` keeps the quotes.
+func quoteWord(s string, d *Directive) string {
+	if s == "" {
+		return s
+	}
+	if words := parseWords(s, d); len(words) == 1 && words[0] == s {
+		return s
+	}
+	return quote(s, d.escapeToken)
+}
+
+// quoteFlag quotes the value of a builder flag, e.g. `--name="a value"`, if
+// it contains whitespace or quotes. The flag parser only reads -- and the
+// name unquoted, so quoting the whole flag would end the flags.
+func quoteFlag(flag string) string {
+	if !strings.ContainsAny(flag, " \t\"'") {
+		return flag
+	}
+	name, value := "--", strings.TrimPrefix(flag, "--")
+	if i := strings.Index(value, "="); i >= 0 {
+		name, value = flag[:len(name)+i+1], value[i+1:]
+	}
+	return name + quote(value, '\\')
+}
+
+func quote(s string, escapeToken rune) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, ch := range s {
+		if ch == '"' || ch == escapeToken {
+			sb.WriteRune(escapeToken)
+		}
+		sb.WriteRune(ch)
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
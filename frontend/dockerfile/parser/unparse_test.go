@@ -0,0 +1,190 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestUnparseRoundTrip(t *testing.T) {
+	for _, dir := range getDirs(t, testDir) {
+		dockerfile := filepath.Join(testDir, dir, "Dockerfile")
+
+		df, err := os.Open(dockerfile)
+		assert.NilError(t, err, dockerfile)
+		defer df.Close()
+
+		result, err := Parse(df)
+		assert.NilError(t, err, dockerfile)
+
+		buf := &bytes.Buffer{}
+		assert.NilError(t, result.Unparse(buf), dockerfile)
+
+		reparsed, err := Parse(bytes.NewReader(buf.Bytes()))
+		assert.NilError(t, err, "In "+dockerfile+":\n"+buf.String())
+		assert.Check(t, is.Equal(result.AST.Dump(), reparsed.AST.Dump()), "In "+dockerfile)
+		assert.Check(t, is.Equal(result.EscapeToken, reparsed.EscapeToken), "In "+dockerfile)
+	}
+}
+
+func TestUnparseRoundTripQuoting(t *testing.T) {
+	for _, dockerfile := range []string{
+		"FROM busybox\nRUN --mount=type=bind,target=\"/my dir\" ls\n",
+		"FROM busybox\nCOPY --chown=\"a b\" src /dst\n",
+		"FROM busybox\nENV a=\nLABEL a= b=c\n",
+	} {
+		result, err := Parse(strings.NewReader(dockerfile))
+		assert.NilError(t, err, dockerfile)
+
+		buf := &bytes.Buffer{}
+		assert.NilError(t, result.Unparse(buf), dockerfile)
+
+		reparsed, err := Parse(bytes.NewReader(buf.Bytes()))
+		assert.NilError(t, err, "In "+dockerfile+":\n"+buf.String())
+		assert.Check(t, result.AST.Equal(reparsed.AST), "In %q:\n%s", dockerfile, buf.String())
+	}
+}
+
+func TestUnparse(t *testing.T) {
+	dockerfile := `from busybox
+copy --from=build a b /dst/
+env FOO bar baz
+label "a b"="c d"
+cmd ["echo", "<hello>"]
+healthcheck --interval=5s CMD ["check", "now"]
+onbuild run make
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+
+	result.AST.Children[0].Next.Value = "alpine"
+
+	buf := &bytes.Buffer{}
+	assert.NilError(t, result.Unparse(buf))
+
+	expected := `FROM alpine
+COPY --from=build a b /dst/
+ENV FOO bar baz
+LABEL "a b"="c d"
+CMD ["echo","<hello>"]
+HEALTHCHECK --interval=5s CMD ["check","now"]
+ONBUILD RUN make
+`
+	assert.Check(t, is.Equal(expected, buf.String()))
+}
+
+func TestUnparseTrailingMeta(t *testing.T) {
+	dockerfile := "FROM alpine\nRUN build.sh   # cache-id: abc\n"
+	result, err := ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{TrailingMeta: true})
+	assert.NilError(t, err)
+
+	buf := &bytes.Buffer{}
+	assert.NilError(t, result.Unparse(buf))
+	assert.Check(t, is.Equal("FROM alpine\nRUN build.sh # cache-id: abc\n", buf.String()))
+}
+
+func TestResultWriteTo(t *testing.T) {
+	result, err := Parse(strings.NewReader(multiStageDockerfile))
+	assert.NilError(t, err)
+
+	var _ io.WriterTo = result
+	buf := &bytes.Buffer{}
+	n, err := result.WriteTo(buf)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(int64(buf.Len()), n))
+
+	reparsed, err := Parse(buf)
+	assert.NilError(t, err)
+	assert.Check(t, result.AST.Equal(reparsed.AST))
+
+	errWrite := errors.New("write failed")
+	_, err = result.WriteTo(&failingWriter{n: 10, err: errWrite})
+	assert.Check(t, is.Equal(errWrite, err))
+}
+
+// failingWriter fails once more than n bytes were written
+type failingWriter struct {
+	n   int
+	err error
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		return 0, w.err
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestParseLossless(t *testing.T) {
+	dockerfile := `# syntax=docker/dockerfile:1
+
+# build stage
+FROM golang AS build
+
+RUN go build \
+    # not a node
+    -o /app .
+# runtime stage \
+FROM alpine
+COPY --from=build /app /app
+
+
+`
+	result, err := ParseWithOptions(strings.NewReader(dockerfile), ParseOptions{Lossless: true})
+	assert.NilError(t, err)
+
+	type line struct {
+		Value    string
+		Original string
+		Line     int
+	}
+	var lines []line
+	for _, child := range result.AST.Children {
+		original := child.Original
+		if child.Value != CommentNode {
+			original = ""
+		}
+		lines = append(lines, line{child.Value, original, child.StartLine})
+	}
+	assert.Check(t, is.DeepEqual([]line{
+		{BlankLineNode, "", 2},
+		{CommentNode, "# build stage", 3},
+		{"from", "", 4},
+		{BlankLineNode, "", 5},
+		{"run", "", 6},
+		{CommentNode, "# runtime stage \\", 9},
+		{"from", "", 10},
+		{"copy", "", 11},
+		{BlankLineNode, "", 12},
+		{BlankLineNode, "", 13},
+	}, lines))
+	assert.Check(t, is.Len(result.Stages(), 2))
+	assert.Check(t, is.Len(result.Stages()[0].Commands, 1))
+
+	buf := &bytes.Buffer{}
+	assert.NilError(t, result.Unparse(buf))
+	assert.Check(t, is.Equal(`# syntax=docker/dockerfile:1
+
+# build stage
+FROM golang AS build
+
+RUN go build     -o /app .
+# runtime stage \
+FROM alpine
+COPY --from=build /app /app
+
+
+`, buf.String()))
+
+	result, err = Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(result.AST.Children, 4))
+}
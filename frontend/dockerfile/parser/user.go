@@ -0,0 +1,59 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/command"
+	"github.com/pkg/errors"
+)
+
+// User is the argument of a USER instruction
+type User struct {
+	User  string // user name or uid
+	Group string // group name or gid, if given
+}
+
+// NumericUser reports whether the user is given as a uid
+func (u *User) NumericUser() bool {
+	return isNumericID(u.User)
+}
+
+// NumericGroup reports whether the group is given as a gid
+func (u *User) NumericGroup() bool {
+	return isNumericID(u.Group)
+}
+
+func isNumericID(id string) bool {
+	_, err := strconv.ParseUint(id, 10, 32)
+	return err == nil
+}
+
+// ParseUser parses the argument of a USER instruction of the form
+// user[:group], where user and group are names or numeric ids. Values
+// referencing build arguments are split but not validated.
+func ParseUser(value string) (*User, error) {
+	parts := strings.Split(value, ":")
+	u := &User{User: parts[0]}
+	if len(parts) > 1 {
+		u.Group = strings.Join(parts[1:], ":")
+	}
+	if strings.Contains(value, "$") {
+		return u, nil
+	}
+	if len(parts) > 2 || u.User == "" || (len(parts) == 2 && u.Group == "") {
+		return nil, errors.Errorf("user %q must be of the form user[:group]", value)
+	}
+	return u, nil
+}
+
+// UserOf returns the parsed argument of a USER instruction.
+func UserOf(node *Node) (*User, error) {
+	if !strings.EqualFold(node.Value, command.User) {
+		return nil, errors.Errorf("%s is not a USER instruction", strings.ToUpper(node.Value))
+	}
+	if node.Next == nil {
+		return nil, errors.New("USER requires an argument")
+	}
+	return ParseUser(node.Next.Value)
+}
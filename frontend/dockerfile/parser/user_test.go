@@ -0,0 +1,51 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestUserOf(t *testing.T) {
+	dockerfile := `FROM busybox
+USER appuser:appgroup
+USER 1000:1000
+USER appuser
+USER $UID:$GID
+USER a:b:c
+`
+	result, err := Parse(strings.NewReader(dockerfile))
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual([]string{
+		`[WARNING]: line 6: invalid USER: user "a:b:c" must be of the form user[:group]`,
+	}, result.Warnings))
+
+	u, err := UserOf(result.AST.Children[1])
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(&User{User: "appuser", Group: "appgroup"}, u))
+	assert.Check(t, !u.NumericUser())
+	assert.Check(t, !u.NumericGroup())
+
+	u, err = UserOf(result.AST.Children[2])
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(&User{User: "1000", Group: "1000"}, u))
+	assert.Check(t, u.NumericUser())
+	assert.Check(t, u.NumericGroup())
+
+	u, err = UserOf(result.AST.Children[3])
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(&User{User: "appuser"}, u))
+
+	u, err = UserOf(result.AST.Children[4])
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(&User{User: "$UID", Group: "$GID"}, u))
+	assert.Check(t, !u.NumericUser())
+
+	_, err = UserOf(result.AST.Children[5])
+	assert.Check(t, is.Error(err, `user "a:b:c" must be of the form user[:group]`))
+
+	_, err = UserOf(result.AST.Children[0])
+	assert.Check(t, is.Error(err, "FROM is not a USER instruction"))
+}
@@ -0,0 +1,338 @@
+package parser
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/command"
+)
+
+// windowsAbsPath matches paths like C:\dir or C:/dir
+var windowsAbsPath = regexp.MustCompile(`^[a-zA-Z]:[\\/]`)
+
+// Validate runs sanity checks that span multiple instructions and returns
+// the problems found, ordered by line. Unlike the problems found while
+// parsing, these are never errors. Rules can be suppressed with the check
+// parser directive, e.g. `# check=skip=WorkdirRelativePath,UndefinedStage`
+// or `# check=skip=all`.
+func (r *Result) Validate() []Warning {
+	var warnings []Warning
+	stages := r.Stages()
+	if len(stages) == 0 {
+		line := 0
+		if len(r.AST.Children) > 0 {
+			line = r.AST.Children[0].StartLine
+		}
+		warnings = append(warnings, Warning{
+			RuleID:   RuleNoFrom,
+			Message:  "Dockerfile has no FROM instruction",
+			Line:     line,
+			Severity: SeverityWarning,
+		})
+	}
+
+	for i, s := range stages {
+		last := map[string]*Node{}
+		var workdir *Node
+		volumes := map[string]int{} // line of the first VOLUME declaring each path
+		defined := map[string]definition{}
+		for _, n := range s.Commands {
+			switch strings.ToLower(n.Value) {
+			case command.Cmd, command.Entrypoint:
+				cmd := strings.ToLower(n.Value)
+				if prev, ok := last[cmd]; ok {
+					warnings = append(warnings, Warning{
+						RuleID:   RuleMultipleCommands,
+						Message:  fmt.Sprintf("%[1]s has no effect, it is overridden by the %[1]s on line %[2]d", strings.ToUpper(cmd), n.StartLine),
+						Line:     prev.StartLine,
+						Severity: SeverityWarning,
+					})
+				}
+				last[cmd] = n
+			case command.Copy:
+				if msg := checkCopyFrom(n, stages[:i]); msg != "" {
+					warnings = append(warnings, Warning{RuleID: RuleUndefinedStage, Message: msg, Line: n.StartLine, Severity: SeverityWarning})
+				}
+			case command.Workdir:
+				if n.Next == nil {
+					continue
+				}
+				dir := n.Next.Value
+				prev := workdir
+				workdir = n
+				if strings.Contains(dir, "$") || strings.HasPrefix(dir, "/") || windowsAbsPath.MatchString(dir) {
+					continue
+				}
+				msg := fmt.Sprintf("relative WORKDIR %q depends on the working directory of the base image", dir)
+				if prev != nil {
+					msg = fmt.Sprintf("relative WORKDIR %q is relative to the WORKDIR on line %d", dir, prev.StartLine)
+				}
+				warnings = append(warnings, Warning{RuleID: RuleWorkdirRelativePath, Message: msg, Line: n.StartLine, Severity: SeverityWarning})
+			case command.Volume:
+				warnings = append(warnings, checkVolume(n, volumes)...)
+			case command.Arg, command.Env:
+				warnings = append(warnings, checkDuplicateVariables(n, defined, r.EscapeToken)...)
+			}
+		}
+		if entrypoint, cmd := last[command.Entrypoint], last[command.Cmd]; entrypoint != nil && cmd != nil && !entrypoint.IsJSON() {
+			warnings = append(warnings, Warning{
+				RuleID:   RuleShellEntrypointIgnoresCmd,
+				Message:  fmt.Sprintf("CMD is ignored, the ENTRYPOINT on line %d is in shell form and doesn't take the CMD on line %d as arguments", entrypoint.StartLine, cmd.StartLine),
+				Line:     cmd.StartLine,
+				Severity: SeverityWarning,
+			})
+		}
+	}
+
+	warnings = skipWarnings(warnings, r.Check)
+	sort.SliceStable(warnings, func(i, j int) bool {
+		return warnings[i].Line < warnings[j].Line
+	})
+	return warnings
+}
+
+// UnpinnedImages returns a warning for every stage based on an image that
+// isn't pinned to a digest, e.g. `FROM alpine:latest` rather than
+// `FROM alpine@sha256:...`, for builds that must be reproducible. Stages
+// based on other stages or on scratch, and bases referencing build
+// arguments, are not reported. Unlike the checks of Validate this one is
+// opt-in, but it can be suppressed the same way.
+func (r *Result) UnpinnedImages() []Warning {
+	var warnings []Warning
+	for i, s := range r.Stages() {
+		base := s.BaseName
+		if s.BaseIndex != -1 || base == "" || strings.EqualFold(base, "scratch") || strings.Contains(base, "$") || strings.Contains(base, "@") {
+			continue
+		}
+		if index, err := strconv.Atoi(base); err == nil && index < i {
+			continue
+		}
+		warnings = append(warnings, Warning{
+			RuleID:   RuleUnpinnedImage,
+			Message:  fmt.Sprintf("base image %q is not pinned to a digest", base),
+			Line:     s.Line,
+			Severity: SeverityWarning,
+		})
+	}
+	return skipWarnings(warnings, r.Check)
+}
+
+// secretName matches the names of build arguments and environment variables
+// that usually hold credentials, e.g. DB_PASSWORD, GITHUB_TOKEN or
+// AWS_SECRET_ACCESS_KEY
+var secretName = regexp.MustCompile(`(?i)(^|_)(PASSWORD|PASSWD|TOKEN|SECRET|API_?KEY|PRIVATE_?KEY)(_|$)`)
+
+// secretValues match values that look like credentials: AWS access key ids,
+// and long hex or base64 strings, which are likely keys or tokens
+var secretValues = []struct {
+	pattern      *regexp.Regexp
+	description  string
+	likeChecksum bool // whether checksums match too
+}{
+	{regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`), "an AWS access key id", false},
+	{regexp.MustCompile(`^[0-9a-fA-F]{32,}$`), "a hex encoded key", true},
+	{regexp.MustCompile(`^[A-Za-z0-9+/]{40,}={0,2}$`), "a base64 encoded key", true},
+}
+
+// checksumName matches the names of variables holding checksums, whose
+// values are long hex strings too
+var checksumName = regexp.MustCompile(`(?i)(SHA|CHECKSUM|DIGEST|HASH)`)
+
+// Secrets returns a warning for every ARG and ENV instruction that looks
+// like it declares a credential, which would be stored in the image: a name
+// matching secretName, or a value matching one of secretValues. Hex values
+// of variables whose name mentions a checksum, e.g. GOLANG_SHA256, and
+// values referencing other variables are not reported. The check is a
+// heuristic, so like UnpinnedImages it is opt-in and can be suppressed.
+func (r *Result) Secrets() []Warning {
+	var warnings []Warning
+	for _, child := range r.AST.Children {
+		for _, v := range declaredVariables(child, r.EscapeToken) {
+			if reason := secretReason(v.name, v.value); reason != "" {
+				warnings = append(warnings, Warning{
+					RuleID:   RuleSecretsInArgOrEnv,
+					Message:  fmt.Sprintf("%s %s may hold a secret, %s, use a secret mount instead", strings.ToUpper(child.Value), v.name, reason),
+					Line:     child.StartLine,
+					Severity: SeverityWarning,
+				})
+			}
+		}
+	}
+	return skipWarnings(warnings, r.Check)
+}
+
+// secretReason returns why the variable looks like it holds a secret, or ""
+func secretReason(name, value string) string {
+	if secretName.MatchString(name) {
+		return "its name looks like a credential"
+	}
+	if strings.Contains(value, "$") {
+		return ""
+	}
+	for _, v := range secretValues {
+		if v.likeChecksum && checksumName.MatchString(name) {
+			continue
+		}
+		if v.pattern.MatchString(value) {
+			return "its value looks like " + v.description
+		}
+	}
+	return ""
+}
+
+// skipWarnings removes the warnings of the rules skipped by the check parser
+// directive
+func skipWarnings(warnings []Warning, check string) []Warning {
+	skip := skippedRules(check)
+	if len(skip) == 0 {
+		return warnings
+	}
+	kept := warnings[:0]
+	for _, w := range warnings {
+		if !skip["all"] && !skip[w.RuleID] {
+			kept = append(kept, w)
+		}
+	}
+	return kept
+}
+
+// skippedRules returns the rules listed by the skip key of the check parser
+// directive, e.g. `skip=WorkdirRelativePath,UndefinedStage;error=true`.
+func skippedRules(check string) map[string]bool {
+	skip := map[string]bool{}
+	for _, part := range strings.Split(check, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) != "skip" {
+			continue
+		}
+		for _, rule := range strings.Split(kv[1], ",") {
+			if rule = strings.TrimSpace(rule); rule != "" {
+				skip[rule] = true
+			}
+		}
+	}
+	return skip
+}
+
+// NormalizeWorkdir returns the WORKDIR path dir for a Linux target, with
+// backslashes turned into forward slashes and redundant separators and dot
+// elements removed, e.g. `src\app/` becomes `src/app`. Paths referencing
+// variables are only converted, not cleaned, as the variables could expand
+// to anything.
+func NormalizeWorkdir(dir string) string {
+	dir = strings.Replace(dir, "\\", "/", -1)
+	if strings.Contains(dir, "$") || dir == "" {
+		return dir
+	}
+	return path.Clean(dir)
+}
+
+// checkVolume warns about the paths of a VOLUME instruction that are
+// relative or already declared in the stage, as recorded in volumes.
+func checkVolume(node *Node, volumes map[string]int) []Warning {
+	var warnings []Warning
+	for n := node.Next; n != nil; n = n.Next {
+		vol := n.Value
+		if vol == "" || strings.Contains(vol, "$") {
+			continue
+		}
+		if !strings.HasPrefix(vol, "/") && !windowsAbsPath.MatchString(vol) {
+			warnings = append(warnings, Warning{
+				RuleID:   RuleVolumeRelativePath,
+				Message:  fmt.Sprintf("VOLUME %q is a relative path, volumes must be absolute paths", vol),
+				Line:     node.StartLine,
+				Severity: SeverityWarning,
+			})
+		}
+		key := path.Clean(vol)
+		if line, ok := volumes[key]; ok {
+			warnings = append(warnings, Warning{
+				RuleID:   RuleDuplicateVolume,
+				Message:  fmt.Sprintf("VOLUME %q is already declared on line %d", vol, line),
+				Line:     node.StartLine,
+				Severity: SeverityWarning,
+			})
+			continue
+		}
+		volumes[key] = node.StartLine
+	}
+	return warnings
+}
+
+// definition is where a variable was last set in a stage and to what
+type definition struct {
+	variable
+	line int
+}
+
+// checkDuplicateVariables warns about the variables of an ARG or ENV
+// instruction that an instruction of the same kind already set in the
+// stage, as recorded in defined, keyed by the lowercased command and the
+// name. This is legal, the last value wins, but often a mistake.
+// Redefinitions extending the previous value, e.g.
+// `ENV PATH=/opt/bin:$PATH`, are fine.
+func checkDuplicateVariables(node *Node, defined map[string]definition, escapeToken rune) []Warning {
+	var warnings []Warning
+	cmd := strings.ToLower(node.Value)
+	for _, v := range declaredVariables(node, escapeToken) {
+		key := cmd + " " + v.name
+		prev, ok := defined[key]
+		defined[key] = definition{variable: v, line: node.StartLine}
+		if !ok || referencesVariable(v.value, v.name) {
+			continue
+		}
+		warnings = append(warnings, Warning{
+			RuleID:   RuleDuplicateVariable,
+			Message:  fmt.Sprintf("%s %s is set on line %d and again on line %d, %s overrides %s", strings.ToUpper(cmd), v.name, prev.line, node.StartLine, v.describe(), prev.describe()),
+			Line:     node.StartLine,
+			Severity: SeverityWarning,
+		})
+	}
+	return warnings
+}
+
+func (v variable) describe() string {
+	if !v.hasValue {
+		return "no default"
+	}
+	return strconv.Quote(v.value)
+}
+
+// referencesVariable reports whether value references the variable name as
+// $name or ${name...}
+func referencesVariable(value, name string) bool {
+	for i := strings.Index(value, "$"); i >= 0; i = strings.Index(value, "$") {
+		value = value[i+1:]
+		ref := strings.TrimPrefix(value, "{")
+		if strings.HasPrefix(ref, name) && (len(ref) == len(name) || !isNameChar(rune(ref[len(name)]), false)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCopyFrom makes sure the --from flag of a COPY refers to one of the
+// previous stages. Names that look like image references can't be told
+// apart from typos and are only reported if they contain no registry, tag
+// or digest.
+func checkCopyFrom(node *Node, previous []Stage) string {
+	for _, from := range flagValues(node.Flags, "from") {
+		if strings.Contains(from, "$") {
+			continue
+		}
+		if index, err := strconv.Atoi(from); err == nil {
+			if index < 0 || index >= len(previous) {
+				return fmt.Sprintf("COPY --from=%s does not refer to a previous stage", from)
+			}
+			continue
+		}
+		if stageByName(previous, from) == -1 && !strings.ContainsAny(from, ":/@") {
+			return fmt.Sprintf("COPY --from=%s does not refer to a previous stage and will be pulled as an image", from)
+		}
+	}
+	return ""
+}
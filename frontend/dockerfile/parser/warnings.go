@@ -0,0 +1,100 @@
+package parser
+
+import "fmt"
+
+// Rule identifiers of the warnings reported while parsing and by the checks
+// of a Result. They are stable, so that specific warnings can be suppressed
+// or turned into failures.
+const (
+	// reported by Validate
+	RuleNoFrom                    = "NoFromInstruction"
+	RuleUndefinedStage            = "UndefinedStage"
+	RuleMultipleCommands          = "MultipleInstructionsDisallowed"
+	RuleWorkdirRelativePath       = "WorkdirRelativePath"
+	RuleDuplicateVolume           = "DuplicateVolume"
+	RuleVolumeRelativePath        = "VolumeRelativePath"
+	RuleShellEntrypointIgnoresCmd = "ShellEntrypointIgnoresCmd"
+	RuleDuplicateVariable         = "DuplicateVariable"
+
+	// reported by UnpinnedImages and Secrets
+	RuleUnpinnedImage     = "UnpinnedImage"
+	RuleSecretsInArgOrEnv = "SecretsInArgOrEnv"
+
+	// reported by ResolveArgs
+	RuleUndefinedVariable = "UndefinedVariable"
+
+	// reported while parsing
+	RuleUnknownInstruction          = "UnknownInstruction"
+	RuleUnrecognizedSpace           = "UnrecognizedSpace"
+	RuleLegacyKeyValueFormat        = "LegacyKeyValueFormat"
+	RuleInvalidExposePort           = "InvalidExposePort"
+	RuleInvalidCopyFlag             = "InvalidCopyFlag"
+	RuleInvalidChecksum             = "InvalidChecksum"
+	RuleInvalidPlatform             = "InvalidPlatform"
+	RuleInvalidHealthcheck          = "InvalidHealthcheck"
+	RuleInvalidRunMount             = "InvalidRunMount"
+	RuleInvalidRunFlag              = "InvalidRunFlag"
+	RuleShellRequiresJSONForm       = "ShellRequiresJSONForm"
+	RuleInvalidStopSignal           = "InvalidStopSignal"
+	RuleInvalidUser                 = "InvalidUser"
+	RuleInvalidOnbuildTrigger       = "InvalidOnbuildTrigger"
+	RuleMaintainerDeprecated        = "MaintainerDeprecated"
+	RuleConsistentInstructionCasing = "ConsistentInstructionCasing"
+	RuleMaxLayerInstructions        = "MaxLayerInstructions"
+	RuleInstructionBeforeFrom       = "InstructionBeforeFrom"
+	RuleMisplacedParserDirective    = "MisplacedParserDirective"
+	RuleNoEmptyContinuation         = "NoEmptyContinuation"
+	RuleTabIndentation              = "TabIndentation"
+	RuleCommentInContinuation       = "CommentInContinuation"
+)
+
+// Severity is the importance of a Warning
+type Severity int
+
+// Severities of warnings, from the least to the most important
+const (
+	// SeverityInfo is for style issues that don't change the result of a
+	// build, e.g. the casing of instructions
+	SeverityInfo Severity = iota
+	// SeverityWarning is for likely mistakes and deprecated features
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Warning is a problem found while parsing or by Validate
+type Warning struct {
+	RuleID   string // stable identifier of the rule that found the problem
+	Message  string
+	Line     int // the line of the instruction the problem was found in
+	Severity Severity
+}
+
+// String formats the warning the way it appears in Result.Warnings
+func (w Warning) String() string {
+	return fmt.Sprintf("[WARNING]: line %d: %s", w.Line, w.Message)
+}
+
+// warningStrings returns the warnings in the format of Result.Warnings
+func warningStrings(warnings []Warning) []string {
+	strs := []string{}
+	emptyContinuationLines := false
+	for _, w := range warnings {
+		strs = append(strs, w.String())
+		if w.RuleID == RuleNoEmptyContinuation {
+			emptyContinuationLines = true
+		}
+	}
+	if emptyContinuationLines {
+		strs = append(strs, "[WARNING]: Empty continuation lines will become errors in a future release.")
+	}
+	return strs
+}